@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/StCredZero/asq/pkg/asq"
+	"github.com/StCredZero/asq/pkg/output"
+)
+
+type ExplainCmd struct {
+	File string `arg:"positional" help:"path to asq query file"`
+	Expr string `arg:"-e,--expression" help:"explain an inline pattern instead of a query file; - reads it from stdin"`
+}
+
+// runExplain prints how the pattern of a query file, or an inline pattern, is
+// turned into a tree-sitter query.
+func runExplain(cmd *ExplainCmd) error {
+	ctx := context.Background()
+	var explanation *asq.Explanation
+	var err error
+	switch {
+	case cmd.File != "" && cmd.Expr != "":
+		return errors.New("give either a query file or -e, not both")
+	case cmd.File == "" && cmd.Expr == "":
+		return errors.New("a query file or -e is required")
+	case cmd.Expr != "":
+		expr, err := readInlinePattern(cmd.Expr)
+		if err != nil {
+			return err
+		}
+		explanation, err = asq.ExplainInlinePattern(ctx, expr)
+		if err != nil {
+			return fmt.Errorf("explaining pattern: %w", err)
+		}
+	default:
+		if explanation, err = asq.ExplainPattern(ctx, cmd.File); err != nil {
+			return fmt.Errorf("explaining pattern: %w", err)
+		}
+	}
+	return output.WriteExplanation(os.Stdout, explanation)
+}
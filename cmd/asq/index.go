@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+type IndexCmd struct {
+	Build    *IndexBuildCmd  `arg:"subcommand:build" help:"Index all Go files below the current directory"`
+	Status   *IndexStatusCmd `arg:"subcommand:status" help:"Report how many indexed files are fresh, stale or missing"`
+	Clear    *IndexClearCmd  `arg:"subcommand:clear" help:"Delete the index"`
+	CacheDir string          `arg:"--cache-dir" default:".asq-cache" help:"directory holding the index"`
+}
+
+type IndexBuildCmd struct{}
+
+type IndexStatusCmd struct{}
+
+type IndexClearCmd struct{}
+
+// openQueryIndex returns the index to consult during a query, or nil when the
+// cache directory does not exist or indexing was disabled.
+func openQueryIndex(cmd *QueryCmd) *asq.Index {
+	if cmd.NoIndex {
+		return nil
+	}
+	if _, err := os.Stat(cmd.CacheDir); err != nil {
+		return nil
+	}
+	index, err := asq.OpenIndex(cmd.CacheDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring index: %v\n", err)
+		return nil
+	}
+	return index
+}
+
+func runIndex(cmd *IndexCmd) error {
+	switch {
+	case cmd.Build != nil:
+		index, err := asq.OpenIndex(cmd.CacheDir)
+		if err != nil {
+			return err
+		}
+		var total, updated int
+		err = asq.WalkGoFiles(".", func(path string, info fs.FileInfo) error {
+			_, changed, err := index.Refresh(path, info)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", path, err)
+				return nil
+			}
+			total++
+			if changed {
+				updated++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("indexed %d files (%d updated) in %s\n", total, updated, cmd.CacheDir)
+
+	case cmd.Status != nil:
+		if _, err := os.Stat(cmd.CacheDir); err != nil {
+			fmt.Printf("no index at %s\n", cmd.CacheDir)
+			return nil
+		}
+		index := &asq.Index{Dir: cmd.CacheDir}
+		entries, err := index.Entries()
+		if err != nil {
+			return err
+		}
+		seen := make(map[string]bool)
+		var fresh, stale, missing int
+		err = asq.WalkGoFiles(".", func(path string, info fs.FileInfo) error {
+			entry := index.Load(path)
+			switch {
+			case entry == nil:
+				missing++
+			case entry.IsFresh(info):
+				fresh++
+			default:
+				stale++
+			}
+			if entry != nil {
+				seen[entry.Path] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		orphaned := 0
+		for _, entry := range entries {
+			if !seen[entry.Path] {
+				orphaned++
+			}
+		}
+		fmt.Printf("index:    %s\n", cmd.CacheDir)
+		fmt.Printf("entries:  %d\n", len(entries))
+		fmt.Printf("fresh:    %d\n", fresh)
+		fmt.Printf("stale:    %d\n", stale)
+		fmt.Printf("missing:  %d\n", missing)
+		fmt.Printf("orphaned: %d\n", orphaned)
+
+	case cmd.Clear != nil:
+		index := &asq.Index{Dir: cmd.CacheDir}
+		if err := index.Clear(); err != nil {
+			return err
+		}
+		fmt.Printf("removed %s\n", cmd.CacheDir)
+
+	default:
+		return fmt.Errorf("expected one of: build, status, clear")
+	}
+	return nil
+}
@@ -1,10 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
-	"strings"
 
 	"github.com/StCredZero/asq/pkg/asq"
 	"github.com/alexflint/go-arg"
@@ -14,14 +13,14 @@ type TreeSitterCmd struct {
 	File string `arg:"positional,required" help:"path to go file "`
 }
 
-type QueryCmd struct {
-	File   string `arg:"positional,required" help:"path to asq query file"`
-	Cursor bool   `arg:"--cursor" help:"Output code snippet in <especially_relevant_code_snippet> format"`
-}
-
 type CLI struct {
 	TreeSitter *TreeSitterCmd `arg:"subcommand:tree-sitter" help:"Generate a tree-sitter query from a Go file"`
 	Query      *QueryCmd      `arg:"subcommand:query" help:"Search for matches using the tree-sitter query from a Go file"`
+	Index      *IndexCmd      `arg:"subcommand:index" help:"Manage the on-disk index of parsed files"`
+	Rewrite    *RewriteCmd    `arg:"subcommand:rewrite" help:"Replace the matches of a query with its replacement code"`
+	Serve      *ServeCmd      `arg:"subcommand:serve" help:"Keep the workspace parsed in memory and answer queries over stdio or a Unix socket"`
+	Explain    *ExplainCmd    `arg:"subcommand:explain" help:"Show how a pattern is interpreted: its code, node tree, wildcards and query"`
+	Test       *TestCmd       `arg:"subcommand:test" help:"Check patterns against the // asq:expect annotations of their fixture files"`
 }
 
 func main() {
@@ -39,59 +38,46 @@ func main() {
 		fmt.Println(query)
 
 	case cli.Query != nil:
-		// Generate tree-sitter query from file
-		query, err := asq.ExtractTreeSitterQuery(cli.Query.File)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error generating query: %v\n", err)
+		// Exit like grep: 0 if anything matched, 1 if nothing did, 2 on error
+		if err := runQuery(cli.Query); errors.Is(err, asq.ErrNoMatch) {
 			os.Exit(1)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
 		}
 
-		// Walk through current directory recursively
-		err = filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			// Skip non-Go files
-			if !info.IsDir() && filepath.Ext(path) == ".go" && !strings.HasPrefix(filepath.Base(path), "_asq_") {
-				// Validate query against current file
-				matches, err := asq.ValidateTreeSitterQuery(path, query)
-				if err != nil {
-					return nil // Skip this file and continue walking
-				}
-				
-				if cli.Query.Cursor {
-					// Group matches for deduplication
-					groups, err := asq.GroupMatchesForCursorDedup(path, matches)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "Error grouping matches in %s: %v\n", path, err)
-						return nil // Skip this file and continue walking
-					}
-					
-					// Output each group
-					for _, group := range groups {
-						fmt.Printf("<especially_relevant_code_snippet>\n")
-						fmt.Printf("go\n")
-						if group.IsFunction || len(matches) > 1 {
-							// Omit line number for functions or when multiple matches exist in file
-							fmt.Printf("%s\n", group.FilePath)
-						} else {
-							// Show line number only for single root-level matches
-							fmt.Printf("%s:%d\n", group.FilePath, group.StartLine)
-						}
-						fmt.Printf("%s\n", group.Snippet)
-						fmt.Printf("</especially_relevant_code_snippet>\n\n")
-					}
-				} else {
-					for _, match := range matches {
-						fmt.Printf("//asq_match %s:%d:%d\n%s\n", path, match.Row, match.Col, match.Code)
-					}
-				}
-				return nil // Continue walking
-			}
-			return nil
-		})
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error walking directory: %v\n", err)
+	case cli.Rewrite != nil:
+		if err := runRewrite(cli.Rewrite); errors.Is(err, asq.ErrNoMatch) {
+			os.Exit(1)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+
+	case cli.Explain != nil:
+		if err := runExplain(cli.Explain); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+	case cli.Test != nil:
+		// Exit like go test: 1 if a pattern failed, the report says which
+		if err := runTest(cli.Test); errors.Is(err, errTestsFailed) {
+			os.Exit(1)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+	case cli.Index != nil:
+		if err := runIndex(cli.Index); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+	case cli.Serve != nil:
+		if err := runServe(cli.Serve); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 	}
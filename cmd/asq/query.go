@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StCredZero/asq/pkg/asq"
+	"github.com/StCredZero/asq/pkg/output"
+)
+
+type QueryCmd struct {
+	File        string        `arg:"positional" help:"path to asq query file; with -e, the first path to search"`
+	Paths       []string      `arg:"positional" help:"files or directories to search [default: .]"`
+	Expr        string        `arg:"-e,--expression" help:"inline pattern instead of a query file: a Go expression, return statement or function declaration; - reads it from stdin"`
+	Cursor      bool          `arg:"--cursor" help:"Output code snippet in <especially_relevant_code_snippet> format (same as --format=cursor)"`
+	Format      string        `arg:"--format" default:"text" help:"output format: text, cursor, vimgrep, emacs, jsonl or sarif"`
+	FilesOnly   bool          `arg:"-l,--files-with-matches" help:"print only the names of files with matches"`
+	Count       bool          `arg:"-c,--count" help:"print only the number of matches per file"`
+	After       int           `arg:"-A,--after-context" help:"print this many lines after each match"`
+	Before      int           `arg:"-B,--before-context" help:"print this many lines before each match"`
+	Context     int           `arg:"-C,--context" help:"print this many lines before and after each match"`
+	Color       string        `arg:"--color" default:"auto" help:"highlight matches: auto, always or never"`
+	Columns     string        `arg:"--column-encoding" help:"count columns in byte, utf-8 (characters) or utf-16 (code units); the default depends on the format"`
+	GroupBy     string        `arg:"--group-by" default:"function" help:"with --cursor, show the enclosing function, declaration or statement of matches"`
+	Definitions bool          `arg:"--definitions" help:"with --cursor, also show the types, variables and methods the matches use"`
+	MaxTokens   int           `arg:"--max-tokens" help:"with --cursor, fit the output in about this many tokens"`
+	Template    string        `arg:"--template" help:"Go text/template, or a file holding one, to execute for each match"`
+	Header      string        `arg:"--header-template" help:"template, or a file holding one, to execute before the first match"`
+	Footer      string        `arg:"--footer-template" help:"template, or a file holding one, to execute after the last match"`
+	MaxCount    int           `arg:"-m,--max-count" help:"stop after this many matches"`
+	Timeout     time.Duration `arg:"--timeout" help:"stop the whole search after this long"`
+	FileTimeout time.Duration `arg:"--file-timeout" default:"10s" help:"skip files that take longer than this to parse and search"`
+	MaxFileSize string        `arg:"--max-filesize" help:"skip files larger than this size, e.g. 512K or 10M"`
+	MatchLimit  int           `arg:"--match-limit" help:"stop searching a file after this many matches"`
+	CacheDir    string        `arg:"--cache-dir" default:".asq-cache" help:"index directory used to skip files that cannot match, if it exists"`
+	NoIndex     bool          `arg:"--no-index" help:"Do not consult the index"`
+	WalkFlags
+}
+
+// WalkFlags select the files a command searches.
+type WalkFlags struct {
+	Include       []string `arg:"--include,separate" help:"search only files matching this gitignore-style glob; repeatable"`
+	Exclude       []string `arg:"--exclude,separate" help:"skip files and directories matching this gitignore-style glob; repeatable"`
+	NoIgnore      bool     `arg:"--no-ignore" help:"also search vendor, node_modules and testdata directories and files listed in .gitignore or .ignore"`
+	Follow        bool     `arg:"-L,--follow" help:"follow symbolic links"`
+	SkipGenerated bool     `arg:"--skip-generated" default:"true" help:"skip files marked // Code generated ... DO NOT EDIT.; --skip-generated=false searches them"`
+	Tests         string   `arg:"--tests" default:"include" help:"search test files too (include), leave them out (exclude) or search only them (only)"`
+	Build         bool     `arg:"--build" help:"leave out files excluded from the build for $GOOS and $GOARCH by build constraints or file name suffixes"`
+	Tags          string   `arg:"--tags" help:"comma-separated build tags to satisfy; implies --build"`
+}
+
+func (f WalkFlags) options() (asq.WalkOptions, error) {
+	tests, err := asq.ParseTestFilter(f.Tests)
+	if err != nil {
+		return asq.WalkOptions{}, err
+	}
+	opts := asq.WalkOptions{
+		Include:        f.Include,
+		Exclude:        f.Exclude,
+		NoIgnore:       f.NoIgnore,
+		FollowSymlinks: f.Follow,
+		Generated:      !f.SkipGenerated,
+		Tests:          tests,
+	}
+	if f.Build || f.Tags != "" {
+		// build.Default follows $GOOS, $GOARCH and $CGO_ENABLED
+		ctx := build.Default
+		ctx.BuildTags = append(slices.Clip(ctx.BuildTags), strings.FieldsFunc(f.Tags, func(r rune) bool {
+			return r == ',' || r == ' '
+		})...)
+		opts.Build = &ctx
+	}
+	return opts, nil
+}
+
+// searchRoots returns the paths to search, the current directory if none were
+// given.
+func searchRoots(paths []string) []string {
+	if len(paths) == 0 {
+		return []string{"."}
+	}
+	return paths
+}
+
+// runQuery searches the given paths, or the current directory. It returns asq.ErrNoMatch if nothing
+// matched. Files that cannot be searched are reported on stderr as they are met
+// and make runQuery fail once the search is complete.
+func runQuery(cmd *QueryCmd) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+
+	file, paths := cmd.File, cmd.Paths
+	if cmd.Expr != "" && file != "" {
+		// Without a query file every positional argument is a path
+		file, paths = "", append([]string{file}, paths...)
+	}
+	query, pattern, err := loadPattern(ctx, file, cmd.Expr)
+	if err != nil {
+		return err
+	}
+
+	maxFileSize, err := parseSize(cmd.MaxFileSize)
+	if err != nil {
+		return fmt.Errorf("invalid --max-filesize: %w", err)
+	}
+	opts := asq.SearchOptions{
+		Index:             openQueryIndex(cmd),
+		MaxCount:          cmd.MaxCount,
+		MaxFileSize:       maxFileSize,
+		FileTimeout:       cmd.FileTimeout,
+		MaxMatchesPerFile: cmd.MatchLimit,
+		Pattern:           pattern.ID,
+	}
+	if opts.Walk, err = cmd.options(); err != nil {
+		return err
+	}
+
+	format := cmd.Format
+	if cmd.Cursor {
+		format = "cursor"
+	}
+	color, err := useColor(cmd.Color)
+	if err != nil {
+		return err
+	}
+	boundary, err := asq.ParseBoundary(cmd.GroupBy)
+	if err != nil {
+		return err
+	}
+	columns, err := asq.ParseColumnEncoding(cmd.Columns)
+	if err != nil {
+		return err
+	}
+	var templates [3]string
+	for i, t := range []string{cmd.Template, cmd.Header, cmd.Footer} {
+		if templates[i], err = loadTemplate(t); err != nil {
+			return err
+		}
+	}
+	before, after := cmd.Before, cmd.After
+	if before == 0 {
+		before = cmd.Context
+	}
+	if after == 0 {
+		after = cmd.Context
+	}
+	outOpts := output.Options{
+		Format:           format,
+		Patterns:         []asq.PatternInfo{pattern},
+		FilesWithMatches: cmd.FilesOnly,
+		Count:            cmd.Count,
+		Before:           before,
+		After:            after,
+		Color:            color,
+		Boundary:         boundary,
+		Columns:          columns,
+		Definitions:      cmd.Definitions,
+		MaxTokens:        cmd.MaxTokens,
+		Template:         templates[0],
+		Header:           templates[1],
+		Footer:           templates[2],
+	}
+	out, err := output.New(os.Stdout, outOpts)
+	if err != nil {
+		return err
+	}
+	opts.Scopes = outOpts.GroupsMatches()
+
+	var skipped []asq.Match
+	var skipReasons []error
+	matched, failed := false, 0
+	for match, err := range asq.SearchPaths(ctx, searchRoots(paths), query, opts) {
+		if err == nil {
+			matched = true
+			if err := out.WriteMatch(match); err != nil {
+				return fmt.Errorf("writing output: %w", err)
+			}
+			continue
+		}
+		switch {
+		case asq.IsSkip(err):
+			skipped = append(skipped, match)
+			skipReasons = append(skipReasons, err)
+		case ctx.Err() != nil, errors.Is(err, asq.ErrInvalidQuery):
+			out.Close()
+			printSkipSummary(skipped, skipReasons)
+			return err
+		default:
+			// Skip this file and continue walking
+			fmt.Fprintf(os.Stderr, "asq: %s: %v\n", match.File, err)
+			failed++
+		}
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	printSkipSummary(skipped, skipReasons)
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be searched", failed)
+	}
+	if !matched {
+		return asq.ErrNoMatch
+	}
+	return nil
+}
+
+// loadPattern returns the query and description of the pattern in file, or
+// of the inline pattern expr, read from stdin if it is "-".
+func loadPattern(ctx context.Context, file, expr string) (string, asq.PatternInfo, error) {
+	switch {
+	case file != "" && expr != "":
+		return "", asq.PatternInfo{}, errors.New("give either a query file or -e, not both")
+	case file == "" && expr == "":
+		return "", asq.PatternInfo{}, errors.New("a query file or -e is required")
+	case expr != "":
+		expr, err := readInlinePattern(expr)
+		if err != nil {
+			return "", asq.PatternInfo{}, err
+		}
+		query, err := asq.ExtractInlineTreeSitterQuery(ctx, expr)
+		if err != nil {
+			return "", asq.PatternInfo{}, fmt.Errorf("generating query: %w", err)
+		}
+		return query, asq.InlinePatternInfo(), nil
+	}
+
+	// Generate tree-sitter query from file
+	query, err := asq.ExtractTreeSitterQueryContext(ctx, file)
+	if err != nil {
+		return "", asq.PatternInfo{}, fmt.Errorf("generating query: %w", err)
+	}
+	pattern, err := asq.LoadPatternInfo(file)
+	if err != nil {
+		return "", asq.PatternInfo{}, err
+	}
+	return query, pattern, nil
+}
+
+// readInlinePattern returns the inline pattern expr, read from stdin if it is
+// "-".
+func readInlinePattern(expr string) (string, error) {
+	if expr != "-" {
+		return expr, nil
+	}
+	snippet, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("reading pattern: %w", err)
+	}
+	return string(snippet), nil
+}
+
+// printSkipSummary lists on stderr the files that were skipped or truncated
+// because of a size, time or match limit.
+func printSkipSummary(skipped []asq.Match, reasons []error) {
+	if len(skipped) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "asq: %d files skipped or truncated:\n", len(skipped))
+	for i, match := range skipped {
+		fmt.Fprintf(os.Stderr, "  %s: %v\n", match.File, reasons[i])
+	}
+}
+
+// useColor interprets --color. In auto mode colours are used when stdout is a
+// terminal, unless NO_COLOR is set or TERM is "dumb".
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return false, nil
+		}
+		info, err := os.Stdout.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("invalid --color %q (expected auto, always or never)", mode)
+}
+
+// loadTemplate returns the contents of the file named by s if there is one,
+// and s itself otherwise.
+func loadTemplate(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	info, err := os.Stat(s)
+	if err != nil || info.IsDir() {
+		return s, nil
+	}
+	contents, err := os.ReadFile(s)
+	if err != nil {
+		return "", err
+	}
+	return string(contents), nil
+}
+
+// parseSize parses a byte count with an optional K, M or G suffix.
+// The empty string means no limit.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
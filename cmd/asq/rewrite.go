@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/StCredZero/asq/pkg/asq"
+	"github.com/StCredZero/asq/pkg/output"
+)
+
+type RewriteCmd struct {
+	File        string        `arg:"positional,required" help:"path to asq query file with an //asq_replace_start ... //asq_replace_end region"`
+	Paths       []string      `arg:"positional" help:"files or directories to rewrite [default: .]"`
+	DryRun      bool          `arg:"--dry-run" help:"print the rewrite as a unified diff instead of changing files"`
+	Patch       string        `arg:"--patch" help:"write the rewrite to this file as a patch for git apply instead of changing files"`
+	Interactive bool          `arg:"-i,--interactive" help:"ask before applying each edit, like git add -p"`
+	Verify      bool          `arg:"--verify" help:"type-check the rewritten packages and leave out edits that introduce type errors"`
+	Imports     []string      `arg:"--import,separate" help:"import path of a package the replacement uses, as path or name=path; repeatable"`
+	Color       string        `arg:"--color" default:"auto" help:"colour diffs: auto, always or never"`
+	Timeout     time.Duration `arg:"--timeout" help:"stop the whole search after this long"`
+	FileTimeout time.Duration `arg:"--file-timeout" default:"10s" help:"skip files that take longer than this to parse and search"`
+	MaxFileSize string        `arg:"--max-filesize" help:"skip files larger than this size, e.g. 512K or 10M"`
+	WalkFlags
+}
+
+// rewriter applies a replacement to the files of a rewrite, or shows it as a
+// diff.
+type rewriter struct {
+	replacement *asq.Replacement
+	pattern     asq.PatternInfo
+	diff        io.Writer // --dry-run output, or nil
+	color       bool
+	patch       io.Writer // --patch output, or nil
+	summary     io.Writer
+	review      *output.Reviewer // --interactive review, or nil
+}
+
+// runRewrite replaces the matches of the query below the given paths, or the
+// current directory, with its replacement. It returns asq.ErrNoMatch if nothing
+// matched. The query file itself is never rewritten.
+func runRewrite(cmd *RewriteCmd) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+
+	query, err := asq.ExtractRewriteQuery(ctx, cmd.File)
+	if err != nil {
+		return fmt.Errorf("generating query: %w", err)
+	}
+	replacement, err := asq.LoadReplacement(cmd.File)
+	if err != nil {
+		return err
+	}
+	if err := replacement.CheckQuery(query); err != nil {
+		return err
+	}
+	for _, imp := range cmd.Imports {
+		name, path := asq.ParseImport(imp)
+		replacement.Imports[name] = path
+	}
+	pattern, err := asq.LoadPatternInfo(cmd.File)
+	if err != nil {
+		return err
+	}
+	rw := &rewriter{replacement: replacement, pattern: pattern, summary: os.Stdout}
+	if cmd.DryRun {
+		// Keep stdout a diff that can be piped to git apply
+		rw.diff, rw.summary = os.Stdout, os.Stderr
+		if rw.color, err = useColor(cmd.Color); err != nil {
+			return err
+		}
+	}
+	if cmd.Interactive {
+		color, err := useColor(cmd.Color)
+		if err != nil {
+			return err
+		}
+		rw.review = output.NewReviewer(os.Stdin, os.Stdout, color)
+		if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			rw.review.EditText = editText
+		}
+	}
+	var patch *os.File
+	var patchBuf *bufio.Writer
+	if cmd.Patch != "" {
+		if patch, err = os.Create(cmd.Patch); err != nil {
+			return err
+		}
+		defer patch.Close()
+		patchBuf = bufio.NewWriter(patch)
+		rw.patch = patchBuf
+	}
+	maxFileSize, err := parseSize(cmd.MaxFileSize)
+	if err != nil {
+		return fmt.Errorf("invalid --max-filesize: %w", err)
+	}
+	opts := asq.SearchOptions{
+		MaxFileSize: maxFileSize,
+		FileTimeout: cmd.FileTimeout,
+	}
+	if opts.Walk, err = cmd.options(); err != nil {
+		return err
+	}
+	queryFile, err := filepath.Abs(cmd.File)
+	if err != nil {
+		return err
+	}
+
+	// Matches arrive consecutively per file, so each file is rewritten once
+	// its last match is in
+	var file string
+	var matches []asq.Match
+	var pending []*asq.FileRewrite
+	matched, failed := false, 0
+	flush := func() {
+		if len(matches) == 0 {
+			return
+		}
+		fr, err := rw.prepare(file, matches)
+		if err == nil && cmd.Verify {
+			// Packages are checked once all their files are rewritten
+			pending = append(pending, fr)
+		} else if err == nil {
+			err = rw.finish(fr)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "asq: %s: %v\n", file, err)
+			failed++
+		}
+		matches = nil
+	}
+	for match, err := range asq.SearchPaths(ctx, searchRoots(cmd.Paths), query, opts) {
+		if rw.review != nil && rw.review.Quit() {
+			break
+		}
+		if err != nil {
+			switch {
+			case ctx.Err() != nil, errors.Is(err, asq.ErrInvalidQuery):
+				return err
+			default:
+				fmt.Fprintf(os.Stderr, "asq: %s: %v\n", match.File, err)
+				failed++
+			}
+			continue
+		}
+		if abs, err := filepath.Abs(match.File); err == nil && abs == queryFile {
+			continue
+		}
+		matched = true
+		if match.File != file {
+			flush()
+			file = match.File
+		}
+		matches = append(matches, match)
+	}
+	flush()
+
+	if len(pending) > 0 {
+		verified, err := asq.NewVerifier().Verify(pending)
+		if err != nil {
+			return fmt.Errorf("verifying rewrite: %w", err)
+		}
+		for _, fr := range verified {
+			if err := rw.finish(fr); err != nil {
+				fmt.Fprintf(os.Stderr, "asq: %s: %v\n", fr.Path, err)
+				failed++
+			}
+		}
+	}
+	if patch != nil {
+		if err := patchBuf.Flush(); err != nil {
+			return fmt.Errorf("writing patch: %w", err)
+		}
+		if err := patch.Close(); err != nil {
+			return fmt.Errorf("writing patch: %w", err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be rewritten", failed)
+	}
+	if !matched {
+		return asq.ErrNoMatch
+	}
+	return nil
+}
+
+// prepare computes the rewrite of one file, with only the edits accepted if
+// they are reviewed.
+func (rw *rewriter) prepare(file string, matches []asq.Match) (*asq.FileRewrite, error) {
+	fr, err := asq.RewriteFile(file, matches, rw.replacement)
+	if err != nil {
+		return nil, err
+	}
+	if rw.review != nil {
+		return rw.review.Review(fr)
+	}
+	return fr, nil
+}
+
+// finish applies a rewrite, or writes its diff, and reports the number of
+// edits. Matches left alone, other than those rejected during the review, are
+// reported on stderr with the reason.
+func (rw *rewriter) finish(fr *asq.FileRewrite) error {
+	file := fr.Path
+	for _, skipped := range fr.Skipped {
+		if errors.Is(skipped.Reason, asq.ErrRejected) {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "asq: %s:%d:%d: skipped: %v\n", file, skipped.Match.Row, skipped.Match.Col+1, skipped.Reason)
+	}
+	if !fr.Changed() {
+		return nil
+	}
+
+	if rw.diff != nil {
+		if err := output.WriteDiff(rw.diff, file, fr.Original, fr.Rewritten, rw.color); err != nil {
+			return err
+		}
+	}
+	if rw.patch != nil {
+		if err := output.WriteDiff(rw.patch, file, fr.Original, fr.Rewritten, false); err != nil {
+			return err
+		}
+	}
+	if rw.diff == nil && rw.patch == nil {
+		if err := fr.Write(); err != nil {
+			return err
+		}
+	}
+
+	edits := "edits"
+	if len(fr.Edits) == 1 {
+		edits = "edit"
+	}
+	fmt.Fprintf(rw.summary, "%s: %d %s from %s\n", file, len(fr.Edits), edits, rw.pattern.ID)
+	return nil
+}
+
+// editText lets the user change replacement code in $EDITOR, or vi.
+func editText(text string) (string, error) {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	tmp, err := os.CreateTemp("", "asq-edit-*.go")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(text + "\n"); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	// EDITOR may hold arguments, such as "code --wait"
+	args := append(strings.Fields(editor), tmp.Name())
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return "", fmt.Errorf("running %s: %w", editor, err)
+	}
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(edited), "\n"), nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/StCredZero/asq/pkg/asq"
+	"github.com/StCredZero/asq/pkg/output"
+)
+
+type ServeCmd struct {
+	Root     string        `arg:"positional" default:"." help:"workspace root to parse and watch"`
+	Socket   string        `arg:"--socket" help:"listen on this Unix socket instead of stdin/stdout"`
+	Interval time.Duration `arg:"--interval" default:"500ms" help:"how often to poll for file changes"`
+	NoWatch  bool          `arg:"--no-watch" help:"do not poll for changes; use the sync method instead"`
+	WalkFlags
+}
+
+// serveRequest is one line of the JSON protocol spoken by asq serve.
+//
+// Methods:
+//   - "query": run the pattern in Pattern (a path to an asq query file) or the
+//     raw tree-sitter Query against the workspace, with columns counted in
+//     ColumnEncoding ("byte", the default, "utf-8" or "utf-16")
+//   - "sync": re-scan the workspace for changed files immediately
+//   - "stats": report the number of files held in memory
+type serveRequest struct {
+	ID             json.RawMessage `json:"id,omitempty"`
+	Method         string          `json:"method"`
+	Pattern        string          `json:"pattern,omitempty"`
+	Query          string          `json:"query,omitempty"`
+	ColumnEncoding string          `json:"column_encoding,omitempty"`
+}
+
+type serveResponse struct {
+	ID        json.RawMessage    `json:"id,omitempty"`
+	Matches   []output.JSONMatch `json:"matches,omitempty"`
+	Files     int                `json:"files"`
+	Changed   int                `json:"changed,omitempty"`
+	ElapsedMs float64            `json:"elapsed_ms"`
+	Error     string             `json:"error,omitempty"`
+}
+
+func runServe(cmd *ServeCmd) error {
+	opts, err := cmd.options()
+	if err != nil {
+		return err
+	}
+	ws, err := asq.NewWorkspaceOptions(cmd.Root, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "asq: parsed %d files in %s\n", ws.Len(), cmd.Root)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if !cmd.NoWatch {
+		go ws.Watch(ctx, cmd.Interval, func(err error) {
+			fmt.Fprintf(os.Stderr, "asq: sync failed: %v\n", err)
+		})
+	}
+
+	if cmd.Socket == "" {
+		return serveConn(ctx, ws, os.Stdin, os.Stdout)
+	}
+
+	// A stale socket left behind by a previous run would make Listen fail
+	os.Remove(cmd.Socket)
+	ln, err := net.Listen("unix", cmd.Socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(cmd.Socket)
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	fmt.Fprintf(os.Stderr, "asq: listening on %s\n", cmd.Socket)
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			if err := serveConn(ctx, ws, conn, conn); err != nil {
+				fmt.Fprintf(os.Stderr, "asq: connection error: %v\n", err)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// serveConn answers newline-delimited JSON requests from r until EOF.
+func serveConn(ctx context.Context, ws *asq.Workspace, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	enc := json.NewEncoder(w)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var req serveRequest
+		var resp serveResponse
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = fmt.Sprintf("invalid request: %v", err)
+		} else {
+			resp = handleServeRequest(ctx, ws, req)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func handleServeRequest(ctx context.Context, ws *asq.Workspace, req serveRequest) (resp serveResponse) {
+	start := time.Now()
+	resp.ID = req.ID
+	defer func() {
+		resp.Files = ws.Len()
+		resp.ElapsedMs = float64(time.Since(start).Microseconds()) / 1000
+	}()
+
+	switch req.Method {
+	case "query":
+		query := req.Query
+		if req.Pattern != "" {
+			var err error
+			if query, err = asq.ExtractTreeSitterQueryContext(ctx, req.Pattern); err != nil {
+				resp.Error = fmt.Sprintf("error generating query: %v", err)
+				return resp
+			}
+		}
+		if query == "" {
+			resp.Error = "query requires a pattern or a query"
+			return resp
+		}
+		columns, err := asq.ParseColumnEncoding(req.ColumnEncoding)
+		if err != nil {
+			resp.Error = err.Error()
+			return resp
+		}
+		results, err := ws.Query(ctx, query)
+		if err != nil {
+			resp.Error = err.Error()
+			return resp
+		}
+		for _, result := range results {
+			for _, match := range result.Matches {
+				resp.Matches = append(resp.Matches, output.NewJSONMatchColumns(match, columns))
+			}
+		}
+	case "sync":
+		changed, err := ws.Sync()
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		resp.Changed = changed
+	case "stats":
+	default:
+		resp.Error = fmt.Sprintf("unknown method %q", req.Method)
+	}
+	return resp
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/StCredZero/asq/pkg/asq"
+	"github.com/StCredZero/asq/pkg/output"
+)
+
+// errTestsFailed is returned once the report of failed pattern tests is
+// written.
+var errTestsFailed = errors.New("pattern tests failed")
+
+type TestCmd struct {
+	Dirs []string `arg:"positional" help:"directories holding pattern files and their fixtures (default: .)"`
+}
+
+// runTest tests the pattern files below the directories against the
+// annotations of their fixtures and writes the report.
+func runTest(cmd *TestCmd) error {
+	ctx := context.Background()
+	var tests []*asq.PatternTest
+	for _, dir := range searchRoots(cmd.Dirs) {
+		dirTests, err := asq.RunPatternTests(ctx, dir)
+		if err != nil {
+			return fmt.Errorf("finding patterns: %w", err)
+		}
+		tests = append(tests, dirTests...)
+	}
+	if len(tests) == 0 {
+		return errors.New("no pattern files found")
+	}
+	if err := output.WritePatternTests(os.Stdout, tests); err != nil {
+		return err
+	}
+	for _, t := range tests {
+		if !t.Passed() {
+			return errTestsFailed
+		}
+	}
+	return nil
+}
@@ -3,12 +3,12 @@ module github.com/StCredZero/asq
 go 1.23.4
 
 require (
+	github.com/alexflint/go-arg v1.5.1
 	github.com/go-enry/go-enry/v2 v2.9.1
 	github.com/smacker/go-tree-sitter v0.0.0-20240827094217-dd81d9e9be82
 )
 
 require (
-	github.com/alexflint/go-arg v1.5.1 // indirect
 	github.com/alexflint/go-scalar v1.2.0 // indirect
 	github.com/go-enry/go-oniguruma v1.2.1 // indirect
 )
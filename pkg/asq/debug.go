@@ -1,13 +1,24 @@
 package asq
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"sync"
+)
 
+// debugging is read once, as Debug is called throughout query building.
+var debugging = sync.OnceValue(func() bool {
+	return os.Getenv("ASQ_DEBUG") != ""
+})
+
+// IsDebugging reports whether the ASQ_DEBUG environment variable is set.
 func IsDebugging() bool {
-	return false
+	return debugging()
 }
 
+// Debug prints a trace message to stderr when debugging.
 func Debug(format string, args ...interface{}) {
 	if IsDebugging() {
-		fmt.Printf(format+"\n", args...)
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
 	}
 }
@@ -0,0 +1,452 @@
+package asq
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxResolveDepth bounds how far typeOf follows variables initialised from
+// other variables, so that cyclic initialisers cannot recurse forever.
+const maxResolveDepth = 8
+
+// DefinitionGroups returns snippet groups for the package-level declarations
+// that the code of matches in filePath depends on: the receiver type of the
+// enclosing method, types used in composite literals and conversions,
+// package-level variables and constants, and methods whose receiver type can
+// be worked out from the syntax, as in e.Inst().Foo(). Declarations are found
+// in every file of the package with go/parser, without type checking. Each
+// declaration appears once, with Definition set to its name and References to
+// the matches that use it; declarations that contain a match are left out
+// since their group already shows them.
+func DefinitionGroups(filePath string, matches []Match) ([]MatchGroup, error) {
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	pkg, file, err := loadPackageDecls(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[ast.Node]*MatchGroup)
+	var order []ast.Node
+	for _, match := range matches {
+		for _, def := range pkg.references(file, match) {
+			group, ok := groups[def.node]
+			if !ok {
+				group = pkg.group(def)
+				if group.FilePath == file.path && group.StartLine <= match.Row && match.Row <= group.EndLine {
+					continue
+				}
+				groups[def.node] = group
+				order = append(order, def.node)
+			}
+			group.References = append(group.References, match)
+		}
+	}
+
+	result := make([]MatchGroup, 0, len(order))
+	for _, node := range order {
+		result = append(result, *groups[node])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].FilePath != result[j].FilePath {
+			return result[i].FilePath < result[j].FilePath
+		}
+		return result[i].StartLine < result[j].StartLine
+	})
+	return result, nil
+}
+
+// packageDecls indexes the package-level declarations of a package by name.
+type packageDecls struct {
+	fset    *token.FileSet
+	types   map[string]*ast.TypeSpec
+	values  map[string]*ast.ValueSpec
+	funcs   map[string]*ast.FuncDecl
+	methods map[string]map[string]*ast.FuncDecl // by receiver type, then name
+	parent  map[ast.Spec]*ast.GenDecl
+	files   map[*token.File]*declFile
+}
+
+type declFile struct {
+	path     string
+	ast      *ast.File
+	contents []byte
+}
+
+// definition is a declaration referenced by a match.
+type definition struct {
+	name string
+	node ast.Node // *ast.TypeSpec, *ast.ValueSpec or *ast.FuncDecl
+}
+
+// loadPackageDecls parses the files in the directory of filePath that belong
+// to its package. Other files that fail to parse are ignored.
+func loadPackageDecls(filePath string) (*packageDecls, *declFile, error) {
+	pkg := &packageDecls{
+		fset:    token.NewFileSet(),
+		types:   make(map[string]*ast.TypeSpec),
+		values:  make(map[string]*ast.ValueSpec),
+		funcs:   make(map[string]*ast.FuncDecl),
+		methods: make(map[string]map[string]*ast.FuncDecl),
+		parent:  make(map[ast.Spec]*ast.GenDecl),
+		files:   make(map[*token.File]*declFile),
+	}
+
+	main, err := pkg.parse(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dir := filepath.Dir(filePath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read package: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		if entry.IsDir() || filepath.Ext(name) != ".go" || strings.HasPrefix(name, "_asq_") || path == filepath.Join(dir, filepath.Base(filePath)) {
+			continue
+		}
+		if f, err := pkg.parse(path); err != nil || f.ast.Name.Name != main.ast.Name.Name {
+			continue
+		}
+	}
+
+	for _, f := range pkg.files {
+		if f.ast.Name.Name == main.ast.Name.Name {
+			pkg.index(f.ast)
+		}
+	}
+	return pkg, main, nil
+}
+
+func (p *packageDecls) parse(path string) (*declFile, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	astFile, err := parser.ParseFile(p.fset, path, contents, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrParse, err)
+	}
+	f := &declFile{path: path, ast: astFile, contents: contents}
+	p.files[p.fset.File(astFile.Pos())] = f
+	return f, nil
+}
+
+func (p *packageDecls) index(f *ast.File) {
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				p.funcs[decl.Name.Name] = decl
+				continue
+			}
+			recv := baseTypeName(decl.Recv.List[0].Type)
+			if p.methods[recv] == nil {
+				p.methods[recv] = make(map[string]*ast.FuncDecl)
+			}
+			p.methods[recv][decl.Name.Name] = decl
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				p.parent[spec] = decl
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					p.types[spec.Name.Name] = spec
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						p.values[name.Name] = spec
+					}
+				}
+			}
+		}
+	}
+}
+
+// references returns the declarations used by the code of match, in order of
+// first use.
+func (p *packageDecls) references(file *declFile, match Match) []definition {
+	tf := p.fset.File(file.ast.Pos())
+	if match.StartByte < 0 || match.EndByte > tf.Size() {
+		return nil
+	}
+	start, end := tf.Pos(match.StartByte), tf.Pos(match.EndByte)
+
+	var fn *ast.FuncDecl
+	for _, decl := range file.ast.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok && fd.Pos() <= start && end <= fd.End() {
+			fn = fd
+		}
+	}
+
+	var defs []definition
+	seen := make(map[ast.Node]bool)
+	add := func(name string, node ast.Node) {
+		if node != nil && !seen[node] {
+			seen[node] = true
+			defs = append(defs, definition{name: name, node: node})
+		}
+	}
+	addType := func(name string) {
+		if spec, ok := p.types[name]; ok {
+			add(name, spec)
+		}
+	}
+
+	scope := &resolveScope{}
+	if fn != nil {
+		scope = p.functionScope(fn)
+		if scope.recvType != "" {
+			addType(scope.recvType)
+		}
+	}
+
+	// Field and method names, and keys of struct literals, are not references
+	// to package-level names
+	skip := make(map[*ast.Ident]bool)
+	ast.Inspect(file.ast, func(n ast.Node) bool {
+		if n == nil || n.End() <= start || end <= n.Pos() {
+			return false
+		}
+		if n.Pos() < start || end < n.End() {
+			return true // only partly inside the match; look at its children
+		}
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			skip[n.Sel] = true
+			if recv := p.typeOf(n.X, scope, 0); recv != "" {
+				if method, ok := p.methods[recv][n.Sel.Name]; ok {
+					addType(recv)
+					add(recv+"."+n.Sel.Name, method)
+				}
+			}
+		case *ast.KeyValueExpr:
+			if key, ok := n.Key.(*ast.Ident); ok {
+				skip[key] = true
+			}
+		case *ast.Ident:
+			if skip[n] || scope.locals[n.Name] || n.Name == scope.recvName {
+				return false
+			}
+			if _, ok := p.types[n.Name]; ok {
+				addType(n.Name)
+			} else if spec, ok := p.values[n.Name]; ok {
+				add(n.Name, spec)
+				addType(p.typeOf(n, scope, 0))
+			}
+		}
+		return true
+	})
+	return defs
+}
+
+// resolveScope describes the function enclosing a match.
+type resolveScope struct {
+	recvName, recvType string
+	locals             map[string]bool // names declared in the function
+}
+
+func (p *packageDecls) functionScope(fn *ast.FuncDecl) *resolveScope {
+	scope := &resolveScope{locals: make(map[string]bool)}
+	declare := func(fields *ast.FieldList) {
+		if fields == nil {
+			return
+		}
+		for _, field := range fields.List {
+			for _, name := range field.Names {
+				scope.locals[name.Name] = true
+			}
+		}
+	}
+	if fn.Recv != nil && len(fn.Recv.List) > 0 {
+		recv := fn.Recv.List[0]
+		scope.recvType = baseTypeName(recv.Type)
+		if len(recv.Names) > 0 {
+			scope.recvName = recv.Names[0].Name
+		}
+	}
+	declare(fn.Type.Params)
+	declare(fn.Type.Results)
+	if fn.Body == nil {
+		return scope
+	}
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			if n.Tok == token.DEFINE {
+				for _, lhs := range n.Lhs {
+					if id, ok := lhs.(*ast.Ident); ok {
+						scope.locals[id.Name] = true
+					}
+				}
+			}
+		case *ast.RangeStmt:
+			if n.Tok == token.DEFINE {
+				for _, x := range []ast.Expr{n.Key, n.Value} {
+					if id, ok := x.(*ast.Ident); ok {
+						scope.locals[id.Name] = true
+					}
+				}
+			}
+		case *ast.ValueSpec:
+			for _, name := range n.Names {
+				scope.locals[name.Name] = true
+			}
+		case *ast.TypeSpec:
+			scope.locals[n.Name.Name] = true
+		case *ast.FuncLit:
+			declare(n.Type.Params)
+			declare(n.Type.Results)
+		}
+		return true
+	})
+	delete(scope.locals, scope.recvName)
+	return scope
+}
+
+// typeOf returns the name of the package-level type of expr, as far as it
+// can be told from the syntax, or "".
+func (p *packageDecls) typeOf(expr ast.Expr, scope *resolveScope, depth int) string {
+	if depth > maxResolveDepth {
+		return ""
+	}
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if e.Name == scope.recvName && scope.recvName != "" {
+			return scope.recvType
+		}
+		if scope.locals[e.Name] {
+			return ""
+		}
+		spec, ok := p.values[e.Name]
+		if !ok {
+			return ""
+		}
+		if spec.Type != nil {
+			return p.typeName(spec.Type)
+		}
+		for i, name := range spec.Names {
+			if name.Name == e.Name && i < len(spec.Values) && len(spec.Names) == len(spec.Values) {
+				return p.typeOf(spec.Values[i], &resolveScope{}, depth+1)
+			}
+		}
+	case *ast.ParenExpr:
+		return p.typeOf(e.X, scope, depth+1)
+	case *ast.StarExpr:
+		return p.typeOf(e.X, scope, depth+1)
+	case *ast.UnaryExpr:
+		if e.Op == token.AND {
+			return p.typeOf(e.X, scope, depth+1)
+		}
+	case *ast.CompositeLit:
+		return p.typeName(e.Type)
+	case *ast.CallExpr:
+		switch fun := e.Fun.(type) {
+		case *ast.Ident:
+			if fun.Name == "new" && len(e.Args) == 1 && !scope.locals[fun.Name] {
+				return p.typeName(e.Args[0])
+			}
+			if _, ok := p.types[fun.Name]; ok {
+				return fun.Name // conversion
+			}
+			if fd, ok := p.funcs[fun.Name]; ok && !scope.locals[fun.Name] {
+				return p.resultType(fd)
+			}
+		case *ast.SelectorExpr:
+			if recv := p.typeOf(fun.X, scope, depth+1); recv != "" {
+				if method, ok := p.methods[recv][fun.Sel.Name]; ok {
+					return p.resultType(method)
+				}
+			}
+		case *ast.ParenExpr:
+			return p.typeName(fun.X) // conversion such as (*T)(x)
+		}
+	}
+	return ""
+}
+
+// resultType returns the type name of a function's single result, or "".
+func (p *packageDecls) resultType(fd *ast.FuncDecl) string {
+	results := fd.Type.Results
+	if results == nil || len(results.List) != 1 || len(results.List[0].Names) > 1 {
+		return ""
+	}
+	return p.typeName(results.List[0].Type)
+}
+
+// typeName returns the name of the package-level type expr denotes, looking
+// through pointers and type arguments, or "".
+func (p *packageDecls) typeName(expr ast.Expr) string {
+	name := baseTypeName(expr)
+	if _, ok := p.types[name]; ok {
+		return name
+	}
+	return ""
+}
+
+func baseTypeName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.StarExpr:
+		return baseTypeName(e.X)
+	case *ast.ParenExpr:
+		return baseTypeName(e.X)
+	case *ast.IndexExpr:
+		return baseTypeName(e.X)
+	case *ast.IndexListExpr:
+		return baseTypeName(e.X)
+	}
+	return ""
+}
+
+// group returns the snippet group of a definition. A declaration that is
+// alone in its var, const or type keyword is shown whole, with its doc
+// comment; one of a parenthesised list is shown on its own.
+func (p *packageDecls) group(def definition) *MatchGroup {
+	var node ast.Node = def.node
+	var doc *ast.CommentGroup
+	var kind string
+	switch n := def.node.(type) {
+	case *ast.FuncDecl:
+		doc, kind = n.Doc, "method"
+	case *ast.TypeSpec:
+		doc, kind = n.Doc, "type"
+		if decl := p.parent[n]; decl != nil && !decl.Lparen.IsValid() {
+			node, doc = decl, decl.Doc
+		}
+	case *ast.ValueSpec:
+		doc = n.Doc
+		if decl := p.parent[n]; decl != nil {
+			kind = decl.Tok.String()
+			if !decl.Lparen.IsValid() {
+				node, doc = decl, decl.Doc
+			}
+		}
+	}
+
+	startPos := node.Pos()
+	if doc != nil {
+		startPos = doc.Pos()
+	}
+	start, end := p.fset.Position(startPos), p.fset.Position(node.End())
+	f := p.files[p.fset.File(node.Pos())]
+	lines := strings.Split(string(f.contents), "\n")
+	return &MatchGroup{
+		FilePath:   f.path,
+		StartLine:  start.Line,
+		EndLine:    end.Line,
+		Snippet:    strings.Join(lines[start.Line-1:end.Line], "\n"),
+		Kind:       kind,
+		Definition: def.name,
+	}
+}
@@ -0,0 +1,85 @@
+package asq_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+func TestDefinitionGroups(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-definitions-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		"server.go": `package server
+
+func (s *Server) Run() {
+	cfg := Config{Addr: defaultAddr}
+	s.Logger().Printf("%v", cfg)
+}
+`,
+		"types.go": `package server
+
+// Server serves requests.
+type Server struct {
+	log *Logger
+}
+
+type (
+	Config struct{ Addr string }
+	Logger struct{}
+)
+
+const defaultAddr = ":8080"
+
+func (s *Server) Logger() *Logger { return s.log }
+
+func (l *Logger) Printf(format string, args ...any) {}
+`,
+		"other.go": `package other
+
+type Config struct{}
+`,
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	file := filepath.Join(tmpDir, "server.go")
+	matches, err := asq.ValidateTreeSitterQuery(file, `(block) @x`)
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("Expected one match, got %v, %v", matches, err)
+	}
+
+	groups, err := asq.DefinitionGroups(file, matches)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var got []string
+	for _, g := range groups {
+		got = append(got, g.Definition)
+		if len(g.References) != 1 {
+			t.Errorf("Expected %s to reference the match, got %d references", g.Definition, len(g.References))
+		}
+	}
+	expected := []string{"Server", "Config", "Logger", "defaultAddr", "Server.Logger", "Logger.Printf"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected definitions %v, got %v", expected, got)
+	}
+
+	server := groups[0]
+	if server.FilePath != filepath.Join(tmpDir, "types.go") || server.StartLine != 3 || server.EndLine != 6 {
+		t.Errorf("Unexpected group for Server: %+v", server)
+	}
+	if groups[1].Snippet != "\tConfig struct{ Addr string }" {
+		t.Errorf("Unexpected snippet for Config: %q", groups[1].Snippet)
+	}
+}
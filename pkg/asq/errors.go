@@ -0,0 +1,94 @@
+package asq
+
+import (
+	"errors"
+	"fmt"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+var (
+	// ErrUnsupportedLang is returned for files whose language asq cannot search.
+	ErrUnsupportedLang = errors.New("unsupported language")
+	// ErrNoMatch reports that a search as a whole found nothing. Searching a
+	// single file without matches is not an error and yields no results.
+	ErrNoMatch = errors.New("no match found for capture @x")
+	// ErrInvalidQuery is matched by every InvalidQueryError.
+	ErrInvalidQuery = errors.New("invalid query")
+	// ErrParse is returned when a file cannot be parsed.
+	ErrParse = errors.New("failed to parse file")
+	// ErrUnsupportedPattern is returned for patterns that parse but that asq
+	// cannot turn into a query.
+	ErrUnsupportedPattern = errors.New("unsupported pattern")
+)
+
+// InvalidQueryError describes a tree-sitter query that failed to compile.
+type InvalidQueryError struct {
+	Query   string
+	Offset  uint32                // byte offset of the error in Query
+	Type    sitter.QueryErrorType // kind of error reported by tree-sitter
+	Message string
+}
+
+func (e *InvalidQueryError) Error() string {
+	return fmt.Sprintf("invalid query: %s error at offset %d: %s",
+		sitter.QueryErrorTypeToString(e.Type), e.Offset, e.Message)
+}
+
+// Unwrap makes errors.Is(err, ErrInvalidQuery) hold for every InvalidQueryError.
+func (e *InvalidQueryError) Unwrap() error {
+	return ErrInvalidQuery
+}
+
+// newQuery compiles query for lang, converting tree-sitter's error into an
+// InvalidQueryError. Captures constrained by their own #eq? predicate are
+// renamed apart first; see distinctPredicateCaptures.
+func newQuery(query string, lang *sitter.Language) (*sitter.Query, error) {
+	q, err := sitter.NewQuery([]byte(distinctPredicateCaptures(query)), lang)
+	if err != nil {
+		// Report the error against the query as it was given
+		if q, err = sitter.NewQuery([]byte(query), lang); err == nil {
+			q.Close()
+			return nil, fmt.Errorf("%w: renaming predicate captures broke the query", ErrInvalidQuery)
+		}
+		var qe *sitter.QueryError
+		if errors.As(err, &qe) {
+			return nil, &InvalidQueryError{
+				Query:   query,
+				Offset:  qe.Offset,
+				Type:    qe.Type,
+				Message: qe.Message,
+			}
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	return q, nil
+}
+
+// queryCache compiles a query at most once per language.
+type queryCache struct {
+	query    string
+	compiled map[*sitter.Language]*sitter.Query
+}
+
+func newQueryCache(query string) *queryCache {
+	return &queryCache{query: query, compiled: make(map[*sitter.Language]*sitter.Query)}
+}
+
+func (c *queryCache) get(lang *sitter.Language) (*sitter.Query, error) {
+	if q, ok := c.compiled[lang]; ok {
+		return q, nil
+	}
+	q, err := newQuery(c.query, lang)
+	if err != nil {
+		return nil, err
+	}
+	c.compiled[lang] = q
+	return q, nil
+}
+
+func (c *queryCache) close() {
+	for _, q := range c.compiled {
+		q.Close()
+	}
+}
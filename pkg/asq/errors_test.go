@@ -0,0 +1,65 @@
+package asq_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+func TestValidateTreeSitterQueryErrors(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-errors-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	goFile := filepath.Join(tmpDir, "a.go")
+	if err := os.WriteFile(goFile, []byte("package a\n\nvar x = 1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	textFile := filepath.Join(tmpDir, "notes.md")
+	if err := os.WriteFile(textFile, []byte("# Notes\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	// No match is an empty result, not an error
+	matches, err := asq.ValidateTreeSitterQuery(goFile, `(call_expression) @x`)
+	if err != nil || len(matches) != 0 {
+		t.Errorf("Expected no matches and no error, got %v, %v", matches, err)
+	}
+
+	_, err = asq.ValidateTreeSitterQuery(goFile, `(call_expression (bogus_node)) @x`)
+	var queryErr *asq.InvalidQueryError
+	if !errors.Is(err, asq.ErrInvalidQuery) || !errors.As(err, &queryErr) {
+		t.Fatalf("Expected an InvalidQueryError, got %v", err)
+	}
+	if queryErr.Offset != 18 {
+		t.Errorf("Expected error at offset 18, got %d", queryErr.Offset)
+	}
+
+	if _, err = asq.ValidateTreeSitterQuery(textFile, `(call_expression) @x`); !errors.Is(err, asq.ErrUnsupportedLang) {
+		t.Errorf("Expected ErrUnsupportedLang, got %v", err)
+	}
+
+	if _, err = asq.ValidateTreeSitterQuery(filepath.Join(tmpDir, "missing.go"), `(call_expression) @x`); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestSearchStopsOnInvalidQuery(t *testing.T) {
+	tmpDir := writeSearchFixture(t)
+	defer os.RemoveAll(tmpDir)
+
+	var errs []error
+	for _, err := range asq.SearchDir(context.Background(), tmpDir, `(call_expression (bogus_node)) @x`, asq.SearchOptions{}) {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], asq.ErrInvalidQuery) {
+		t.Errorf("Expected a single ErrInvalidQuery, got %v", errs)
+	}
+}
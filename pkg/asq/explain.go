@@ -0,0 +1,470 @@
+package asq
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"strings"
+)
+
+// Explanation shows how a pattern was interpreted: the code taken from the
+// pattern file, the tree of asq nodes built from it, the /***/ wildcard
+// comments and the identifiers they applied to, and the resulting query.
+type Explanation struct {
+	File string
+	// Region holds the lines between //asq_start and //asq_end.
+	Region []SourceLine
+	// Root is the tree built from the pattern code.
+	Root *ExplainNode
+	// Intervals lists the /***/ comments of the pattern file in order.
+	Intervals []ExplainInterval
+	// Query is the tree-sitter query as asq query runs it, and QueryLines the
+	// same query indented, a line per node that does not fit on one line.
+	Query      string
+	QueryLines []QueryLine
+	// Err records why no query could be built from the tree.
+	Err error
+}
+
+// SourceLine is a numbered line of a pattern file.
+type SourceLine struct {
+	Line int
+	Text string
+}
+
+// ExplainNode is a node of the tree asq builds from pattern code.
+type ExplainNode struct {
+	// Kind is the asq node type, such as "CallExpr". Code without a node type
+	// of its own is named after its go/ast type as well, as in
+	// "DefaultExpr *ast.StarExpr".
+	Kind     string
+	Pos, End token.Position
+	Text     string // the code of the node
+	// Wildcard is set for identifiers that match any identifier, because of
+	// a /***/ comment or an _asq_ name, and Metavariable for _asq_ names that
+	// are captured for replacements.
+	Wildcard     bool
+	Metavariable bool
+	Children     []*ExplainNode
+
+	pos, end token.Pos
+	query    [2]int // the node's part of the query, or -1s if it has none
+}
+
+// ExplainInterval describes a /***/ comment, whose interval runs to the next
+// /***/ comment or to //asq_end.
+type ExplainInterval struct {
+	Pos, End token.Position
+	// Ident is the identifier the comment made a wildcard. It is nil if the
+	// comment was dropped, for the reason given by Reason.
+	Ident  *ExplainNode
+	Reason string
+}
+
+// QueryLine is a line of the indented query and the innermost node whose part
+// of the query the line starts in, if any.
+type QueryLine struct {
+	Text string
+	Node *ExplainNode
+}
+
+// ExplainPattern explains the pattern file at filePath.
+func ExplainPattern(ctx context.Context, filePath string) (*Explanation, error) {
+	return explainPattern(ctx, filePath, nil)
+}
+
+// ExplainInlinePattern explains a pattern snippet given inline; see
+// InlinePatternSource.
+func ExplainInlinePattern(ctx context.Context, snippet string) (*Explanation, error) {
+	src, err := InlinePatternSource(snippet)
+	if err != nil {
+		return nil, err
+	}
+	return explainPattern(ctx, InlinePatternName+".go", src)
+}
+
+func explainPattern(ctx context.Context, filePath string, src []byte) (*Explanation, error) {
+	pattern, err := parsePattern(ctx, filePath, src)
+	if err != nil {
+		return nil, err
+	}
+	e := &Explanation{File: filePath, Region: pattern.region()}
+
+	root := BuildAsqNode(pattern.node, pattern.queryContext)
+	rootSpan := [2]int{-1, -1}
+	if e.Query, e.Err = nodeQuery(root); e.Err == nil {
+		rootSpan = [2]int{0, len(e.Query) - len(" @x")}
+	}
+	x := &explainer{pattern: pattern, query: e.Query, idents: make(map[*ast.Ident]*ExplainNode)}
+	e.Root = x.build(root, rootSpan)
+
+	for _, r := range pattern.queryContext.Intervals() {
+		interval := ExplainInterval{
+			Pos:   pattern.fset.Position(r.Start),
+			End:   pattern.fset.Position(r.End),
+			Ident: x.idents[r.Ident],
+		}
+		if interval.Ident == nil {
+			interval.Reason = x.dropReason(r)
+		}
+		e.Intervals = append(e.Intervals, interval)
+	}
+
+	if e.Err == nil {
+		for _, line := range indentQuery(e.Query) {
+			e.QueryLines = append(e.QueryLines, QueryLine{Text: line.text, Node: e.Root.at(line.offset)})
+		}
+	}
+	return e, nil
+}
+
+// region returns the lines between the //asq_start and //asq_end lines,
+// leaving out //line directives.
+func (p *parsedPattern) region() []SourceLine {
+	tf := p.fset.File(p.startPos)
+	start, end := tf.Offset(p.startPos), tf.Offset(p.endPos)
+	if i := bytes.IndexByte(p.src[start:end], '\n'); i >= 0 {
+		start += i + 1
+	}
+	if i := bytes.LastIndexByte(p.src[start:end], '\n'); i >= 0 {
+		end = start + i + 1
+	} else {
+		// The markers share a line with the code
+		text := strings.TrimSpace(string(p.src[start:end]))
+		return []SourceLine{{Line: p.fset.Position(p.startPos).Line, Text: text}}
+	}
+
+	var lines []SourceLine
+	for off := start; off < end; {
+		next := off + bytes.IndexByte(p.src[off:end], '\n') + 1
+		text := strings.TrimRight(string(p.src[off:next]), "\r\n")
+		if !strings.HasPrefix(strings.TrimSpace(text), "//line ") {
+			lines = append(lines, SourceLine{Line: p.fset.Position(tf.Pos(off)).Line, Text: text})
+		}
+		off = next
+	}
+	return lines
+}
+
+// explainer builds the ExplainNode tree of a pattern.
+type explainer struct {
+	pattern *parsedPattern
+	query   string
+	idents  map[*ast.Ident]*ExplainNode
+	nodes   []*ExplainNode // in pre-order
+}
+
+// build returns the ExplainNode of n, whose part of the query is span.
+func (x *explainer) build(n Node, span [2]int) *ExplainNode {
+	en := &ExplainNode{Kind: nodeKind(n), query: span}
+	x.nodes = append(x.nodes, en)
+	if a := nodeAst(n); a != nil && a.Pos().IsValid() {
+		en.pos, en.end = a.Pos(), a.End()
+		en.Pos, en.End = x.pattern.fset.Position(en.pos), x.pattern.fset.Position(en.end)
+		tf := x.pattern.fset.File(en.pos)
+		en.Text = string(x.pattern.src[tf.Offset(en.pos):tf.Offset(en.end)])
+	}
+	if ident, ok := n.(*Ident); ok {
+		en.Wildcard = ident.Wildcard
+		en.Metavariable = ident.Wildcard && IsMetavariable(ident.Ast.Name)
+		x.idents[ident.Ast] = en
+	}
+
+	// Children are written into the query in the order of their fields, so
+	// each is looked for after the previous one
+	cursor := span[0]
+	for _, child := range nodeChildren(n) {
+		childSpan := [2]int{-1, -1}
+		if cursor >= 0 {
+			if text, err := nodeQueryPart(child); err == nil && text != "" {
+				if i := strings.Index(x.query[cursor:span[1]], text); i >= 0 {
+					childSpan = [2]int{cursor + i, cursor + i + len(text)}
+					cursor = childSpan[1]
+				}
+			}
+		}
+		en.Children = append(en.Children, x.build(child, childSpan))
+	}
+	return en
+}
+
+// dropReason explains why the interval r applied to no identifier.
+func (x *explainer) dropReason(r RangeInterval) string {
+	if r.End <= x.pattern.node.Pos() || r.Start >= x.pattern.node.End() {
+		return "outside the pattern code"
+	}
+	// Find the first identifier in the interval that is not an _asq_ wildcard
+	var first *ast.Ident
+	metavariables := false
+	ast.Inspect(x.pattern.node, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Ident)
+		if first != nil || !ok || ident.Pos() <= r.Start || ident.End() > r.End {
+			return first == nil
+		}
+		if en := x.idents[ident]; en != nil && en.Wildcard {
+			metavariables = true
+			return true
+		}
+		first = ident
+		return false
+	})
+	switch {
+	case first != nil && x.idents[first] == nil:
+		return fmt.Sprintf("%s has no node of its own in the tree to make a wildcard", first.Name)
+	case first != nil:
+		return fmt.Sprintf("dropped before %s was reached", first.Name)
+	case metavariables:
+		return "its only identifiers are _asq_ wildcards already"
+	}
+	return "no identifier in its interval; /***/ only makes identifiers wildcards"
+}
+
+// at returns the innermost node whose part of the query holds offset.
+func (en *ExplainNode) at(offset int) *ExplainNode {
+	if en == nil || offset < en.query[0] || offset >= en.query[1] {
+		return nil
+	}
+	for _, child := range en.Children {
+		if found := child.at(offset); found != nil {
+			return found
+		}
+	}
+	return en
+}
+
+// nodeQuery returns the query that matches n, capturing the match as @x.
+func nodeQuery(n Node) (string, error) {
+	query, err := nodeQueryPart(n)
+	if err != nil {
+		return "", err
+	}
+	return query + " @x", nil
+}
+
+// nodeQueryPart returns the part of a query that n writes.
+func nodeQueryPart(n Node) (string, error) {
+	var sb strings.Builder
+	if err := n.WriteTreeSitterQuery(&sb); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+var nodeType = reflect.TypeOf((*Node)(nil)).Elem()
+
+// nodeChildren returns the asq nodes held by the fields of n, in field order.
+func nodeChildren(n Node) []Node {
+	v := reflect.ValueOf(n)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	var children []Node
+	add := func(f reflect.Value) {
+		if (f.Kind() == reflect.Interface || f.Kind() == reflect.Pointer) && f.IsNil() {
+			return
+		}
+		if child, ok := f.Interface().(Node); ok {
+			children = append(children, child)
+		}
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Type().Field(i).IsExported() {
+			continue
+		}
+		switch f := v.Field(i); {
+		case f.Type().Implements(nodeType):
+			add(f)
+		case f.Kind() == reflect.Slice && f.Type().Elem().Implements(nodeType):
+			for j := 0; j < f.Len(); j++ {
+				add(f.Index(j))
+			}
+		}
+	}
+	return children
+}
+
+// nodeAst returns the Go syntax n was built from, or nil.
+func nodeAst(n Node) ast.Node {
+	switch d := n.(type) {
+	case *DefaultNode:
+		return d.Node
+	case *DefaultExpr:
+		return d.Node
+	}
+	a := n.AstNode()
+	if a == nil {
+		return nil
+	}
+	if v := reflect.ValueOf(a); v.Kind() == reflect.Pointer && v.IsNil() {
+		return nil
+	}
+	return a
+}
+
+// nodeKind names the type of n, and for generic nodes the Go syntax they hold.
+func nodeKind(n Node) string {
+	name := reflect.TypeOf(n).Elem().Name()
+	switch d := n.(type) {
+	case *DefaultNode:
+		return fmt.Sprintf("%s %T", name, d.Node)
+	case *DefaultExpr:
+		return fmt.Sprintf("%s %T", name, d.Node)
+	case *DefaultStmt:
+		return fmt.Sprintf("%s %T", name, d.Ast)
+	case *DefaultDecl:
+		return fmt.Sprintf("%s %T", name, d.Ast)
+	}
+	return name
+}
+
+// queryWidth is the width up to which indentQuery keeps a node on one line.
+const queryWidth = 72
+
+// sexpr is an element of a tree-sitter query: an atom such as a node type,
+// field label, capture or string, or a parenthesised or bracketed list.
+type sexpr struct {
+	start, end int // byte offsets in the query
+	list       []*sexpr
+	isList     bool
+}
+
+// parseSexprs parses the elements of q from offset i up to an unmatched
+// closing parenthesis or bracket, and returns the offset where it stopped.
+func parseSexprs(q string, i int) ([]*sexpr, int) {
+	var items []*sexpr
+	for i < len(q) {
+		switch c := q[i]; c {
+		case ' ', '\t', '\n':
+			i++
+		case ')', ']':
+			return items, i
+		case '(', '[':
+			list, j := parseSexprs(q, i+1)
+			if j < len(q) {
+				j++
+			}
+			items = append(items, &sexpr{start: i, end: j, list: list, isList: true})
+			i = j
+		case '"':
+			j := i + 1
+			for j < len(q) && q[j] != '"' {
+				if q[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(q))
+			items = append(items, &sexpr{start: i, end: j})
+			i = j
+		default:
+			j := i
+			for j < len(q) && !strings.ContainsRune(" \t\n()[]\"", rune(q[j])) {
+				j++
+			}
+			items = append(items, &sexpr{start: i, end: j})
+			i = j
+		}
+	}
+	return items, i
+}
+
+// isPredicate reports whether e is a predicate such as (#eq? @a "b").
+func (e *sexpr) isPredicate(q string) bool {
+	return e.isList && len(e.list) > 0 && strings.HasPrefix(q[e.list[0].start:e.list[0].end], "#")
+}
+
+// hasChildNodes reports whether the list e holds node patterns.
+func (e *sexpr) hasChildNodes(q string) bool {
+	for _, item := range e.list {
+		if item.isList && !item.isPredicate(q) {
+			return true
+		}
+	}
+	return false
+}
+
+// printedLine is a line of an indented query, with the offset in the query of
+// the first node pattern on it, or of its first element.
+type printedLine struct {
+	text   string
+	offset int
+}
+
+// queryPrinter indents a query.
+type queryPrinter struct {
+	query string
+	lines []printedLine
+	first []bool // for each line, whether it has no node pattern yet
+}
+
+// indentQuery splits a query into indented lines, keeping nodes that fit
+// within queryWidth on one line, and starting a line at each field or child
+// node of the others.
+func indentQuery(query string) []printedLine {
+	p := &queryPrinter{query: query}
+	p.newLine(0)
+	for i := 0; i < len(query); i++ { // i++ skips an unmatched closing parenthesis
+		var items []*sexpr
+		items, i = parseSexprs(query, i)
+		for _, item := range items {
+			p.print(item, 0)
+		}
+	}
+	return p.lines
+}
+
+func (p *queryPrinter) newLine(indent int) {
+	p.lines = append(p.lines, printedLine{text: strings.Repeat("  ", indent), offset: -1})
+	p.first = append(p.first, true)
+}
+
+// add appends s, found at offset in the query, to the current line.
+func (p *queryPrinter) add(s string, offset int, isNode bool) {
+	n := len(p.lines) - 1
+	line := &p.lines[n]
+	trimmed := strings.TrimSpace(line.text)
+	if trimmed != "" && !strings.HasSuffix(trimmed, "(") && !strings.HasSuffix(trimmed, "[") && s != ")" && s != "]" {
+		line.text += " "
+	}
+	line.text += s
+	if line.offset < 0 || isNode && p.first[n] {
+		line.offset = offset
+	}
+	if isNode {
+		p.first[n] = false
+	}
+}
+
+func (p *queryPrinter) print(e *sexpr, indent int) {
+	text := p.query[e.start:e.end]
+	if !e.isList || !e.hasChildNodes(p.query) || len(p.lines[len(p.lines)-1].text)+1+len(text) <= queryWidth {
+		p.add(text, e.start, e.isList && !e.isPredicate(p.query))
+		return
+	}
+	p.add(text[:1], e.start, true)
+	// A field label, or a node without one, starts a line; captures,
+	// anchors and predicates stay with what they follow
+	for i, item := range e.list {
+		if i > 0 {
+			itemText := p.query[item.start:item.end]
+			prev := e.list[i-1]
+			if !item.isList && strings.HasSuffix(itemText, ":") ||
+				item.isList && !item.isPredicate(p.query) && !strings.HasSuffix(p.query[prev.start:prev.end], ":") {
+				p.newLine(indent + 1)
+			}
+		}
+		p.print(item, indent+1)
+	}
+	if closing := p.query[e.end-1 : e.end]; e.end > e.start+1 && (closing == ")" || closing == "]") {
+		p.add(closing, e.end-1, false)
+	}
+}
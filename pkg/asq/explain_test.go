@@ -0,0 +1,99 @@
+package asq_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+func TestExplainPattern(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-explain-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The second /***/ ends where the identifier before it does, and the
+	// third can only reach a selector's field name
+	file := filepath.Join(tmpDir, "_asq_p.go")
+	src := "package p\n\nfunc q() {\n\t//asq_start\n\t/***/a/***/.b()./***/c(_asq_X)\n\t//asq_end\n}\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	e, err := asq.ExplainPattern(context.Background(), file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(e.Region) != 1 || e.Region[0].Line != 5 || e.Region[0].Text != "\t/***/a/***/.b()./***/c(_asq_X)" {
+		t.Errorf("Expected the region to be line 5, got %+v", e.Region)
+	}
+
+	expectedQuery, err := asq.ExtractTreeSitterQuery(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if e.Err != nil || e.Query != expectedQuery {
+		t.Errorf("Expected query %s, got %s (%v)", expectedQuery, e.Query, e.Err)
+	}
+	var lines []string
+	for _, line := range e.QueryLines {
+		lines = append(lines, strings.TrimSpace(line.Text))
+	}
+	if got := strings.Join(lines, " "); got != expectedQuery {
+		t.Errorf("Expected the indented query to hold %s, got %s", expectedQuery, got)
+	}
+	if first := e.QueryLines[0]; first.Text != "(call_expression" || first.Node != e.Root {
+		t.Errorf("Expected the first query line to open the root node, got %q for %v", first.Text, first.Node)
+	}
+
+	var kinds []string
+	var walk func(n *asq.ExplainNode)
+	walk = func(n *asq.ExplainNode) {
+		kind := n.Kind
+		if n.Metavariable {
+			kind += "@"
+		} else if n.Wildcard {
+			kind += "*"
+		}
+		kinds = append(kinds, kind)
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(e.Root)
+	expectedKinds := "CallExpr SelectorExpr CallExpr SelectorExpr Ident* Ident@"
+	if got := strings.Join(kinds, " "); got != expectedKinds {
+		t.Errorf("Expected nodes %s, got %s", expectedKinds, got)
+	}
+
+	if len(e.Intervals) != 3 {
+		t.Fatalf("Expected 3 intervals, got %d", len(e.Intervals))
+	}
+	if ident := e.Intervals[0].Ident; ident == nil || ident.Text != "a" || ident.Pos.Line != 5 || ident.Pos.Column != 7 {
+		t.Errorf("Expected the first /***/ to apply to a at 5:7, got %+v", ident)
+	}
+	for i, reason := range []string{"b has no node", "c has no node"} {
+		interval := e.Intervals[i+1]
+		if interval.Ident != nil || !strings.Contains(interval.Reason, reason) {
+			t.Errorf("Expected /***/ %d to be dropped because %s, got %+v", i+2, reason, interval)
+		}
+	}
+}
+
+func TestExplainInlinePattern(t *testing.T) {
+	e, err := asq.ExplainInlinePattern(context.Background(), "foo(/***/x)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(e.Region) != 1 || e.Region[0].Line != 1 || e.Region[0].Text != "foo(/***/x)" {
+		t.Errorf("Expected the snippet as line 1 of the region, got %+v", e.Region)
+	}
+	if e.Root.Pos.Line != 1 || e.Root.Pos.Column != 1 {
+		t.Errorf("Expected the root at 1:1, got %v", e.Root.Pos)
+	}
+}
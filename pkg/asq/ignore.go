@@ -0,0 +1,234 @@
+package asq
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFiles are read from every directory that is walked. Patterns of .ignore
+// take precedence over those of .gitignore in the same directory, as in
+// ripgrep.
+var ignoreFiles = []string{".gitignore", ".ignore"}
+
+// ignorePattern is one line of an ignore file, or one --include or --exclude
+// glob.
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool // the line started with "!"
+	dirOnly bool // the line ended with "/"
+}
+
+// parseIgnorePattern parses a line in gitignore syntax. It reports false for
+// blank lines, comments and invalid patterns, which git ignores as well.
+func parseIgnorePattern(line string) (ignorePattern, bool) {
+	var p ignorePattern
+	line = strings.TrimSuffix(line, "\r")
+	// Trailing spaces are dropped unless escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return p, false
+	}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return p, false
+	}
+	// A slash anywhere but at the end anchors the pattern to the directory of
+	// the ignore file; otherwise it matches a name at any depth
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	re, err := globRegexp(line)
+	if err != nil {
+		return p, false
+	}
+	p.re = re
+	return p, true
+}
+
+// globRegexp translates a gitignore glob into a regular expression over
+// slash-separated relative paths. "*", "?" and character classes never match
+// a slash; a "**" path element matches any number of directories.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); {
+		atElement := i == 0 || glob[i-1] == '/'
+		switch c := glob[i]; {
+		case atElement && strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 3
+		case atElement && glob[i:] == "**":
+			b.WriteString(".*")
+			i += 2
+		case c == '*':
+			b.WriteString("[^/]*")
+			i++
+		case c == '?':
+			b.WriteString("[^/]")
+			i++
+		case c == '[':
+			class, n := globClass(glob[i:])
+			if n == 0 {
+				b.WriteString(`\[`)
+				i++
+				continue
+			}
+			b.WriteString(class)
+			i += n
+		case c == '\\' && i+1 < len(glob):
+			b.WriteString(regexp.QuoteMeta(glob[i+1 : i+2]))
+			i += 2
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			i++
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// globClass translates the character class at the start of s. It returns the
+// number of bytes consumed, or 0 if the class is not terminated.
+func globClass(s string) (string, int) {
+	var b strings.Builder
+	b.WriteString("[")
+	i := 1
+	if i < len(s) && (s[i] == '!' || s[i] == '^') {
+		b.WriteString("^/")
+		i++
+	}
+	for first := true; i < len(s); first = false {
+		switch c := s[i]; {
+		case c == ']' && !first:
+			b.WriteString("]")
+			return b.String(), i + 1
+		case c == '[' && strings.HasPrefix(s[i:], "[:"):
+			end := strings.Index(s[i+2:], ":]")
+			if end < 0 {
+				return "", 0
+			}
+			b.WriteString(s[i : i+2+end+2])
+			i += 2 + end + 2
+		case c == '\\' && i+1 < len(s):
+			b.WriteString(regexp.QuoteMeta(s[i+1 : i+2]))
+			i += 2
+		case c == '-':
+			b.WriteString("-")
+			i++
+		default:
+			b.WriteString(regexp.QuoteMeta(s[i : i+1]))
+			i++
+		}
+	}
+	return "", 0
+}
+
+// match reports whether the pattern matches rel, a slash-separated path
+// relative to the directory of the pattern.
+func (p ignorePattern) match(rel string, isDir bool) bool {
+	return (isDir || !p.dirOnly) && p.re.MatchString(rel)
+}
+
+// ignoreRules holds the patterns of the ignore files of one directory and,
+// through parent, those of the directories above it.
+type ignoreRules struct {
+	dir      string // absolute directory the patterns are relative to
+	patterns []ignorePattern
+	parent   *ignoreRules
+}
+
+// newIgnoreRules returns rules for the patterns of lines relative to dir.
+func newIgnoreRules(dir string, lines []string, parent *ignoreRules) *ignoreRules {
+	r := &ignoreRules{dir: dir, parent: parent}
+	for _, line := range lines {
+		if p, ok := parseIgnorePattern(line); ok {
+			r.patterns = append(r.patterns, p)
+		}
+	}
+	return r
+}
+
+// loadIgnoreRules reads the ignore files of dir, an absolute path. It returns
+// parent unchanged if dir has none.
+func loadIgnoreRules(dir string, parent *ignoreRules) (*ignoreRules, error) {
+	var lines []string
+	for _, name := range ignoreFiles {
+		contents, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(contents))
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	if len(lines) == 0 {
+		return parent, nil
+	}
+	return newIgnoreRules(dir, lines, parent), nil
+}
+
+// ignored reports whether path, an absolute path, is ignored. The last
+// matching pattern of the deepest ignore file with a match decides, so a
+// negated pattern re-includes what an earlier or shallower one excluded.
+func (r *ignoreRules) ignored(path string, isDir bool) bool {
+	for ; r != nil; r = r.parent {
+		rel, err := filepath.Rel(r.dir, path)
+		rel = filepath.ToSlash(rel)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		for i := len(r.patterns) - 1; i >= 0; i-- {
+			if r.patterns[i].match(rel, isDir) {
+				return !r.patterns[i].negate
+			}
+		}
+	}
+	return false
+}
+
+// repositoryIgnoreRules returns the rules of the ignore files above dir, an
+// absolute path, up to the root of the git repository holding it. Outside a
+// repository it returns nil.
+func repositoryIgnoreRules(dir string) (*ignoreRules, error) {
+	var parents []string
+	for d := dir; ; {
+		if _, err := os.Lstat(filepath.Join(d, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return nil, nil
+		}
+		d = parent
+		parents = append(parents, d)
+	}
+	var rules *ignoreRules
+	for i := len(parents) - 1; i >= 0; i-- {
+		var err error
+		if rules, err = loadIgnoreRules(parents[i], rules); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
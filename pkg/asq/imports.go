@@ -0,0 +1,449 @@
+package asq
+
+import (
+	"bytes"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// importPlan records what a rewrite of one file needs to manage its imports.
+type importPlan struct {
+	add  map[string]string // import paths for qualifiers the file does not import
+	used map[string]bool   // package names the original file refers to
+}
+
+// fileImport is an import of a parsed file.
+type fileImport struct {
+	name string // the alias, or the name assumed from the path
+	path string
+	spec *ast.ImportSpec
+	decl *ast.GenDecl
+}
+
+// stdImports maps the names of standard library packages to their paths,
+// leaving out names shared by several packages, such as rand and template.
+// A package shadows its own later major versions, such as encoding/json/v2.
+var stdImports = sync.OnceValue(func() map[string]string {
+	candidates := make(map[string][]string)
+	src := filepath.Join(build.Default.GOROOT, "src")
+	filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(src, path)
+		rel = filepath.ToSlash(rel)
+		switch name := d.Name(); {
+		case rel == "cmd", name == "internal", name == "vendor", name == "testdata":
+			return filepath.SkipDir
+		case rel == ".":
+			return nil
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil
+		}
+		for _, e := range entries {
+			if n := e.Name(); strings.HasSuffix(n, ".go") && !strings.HasSuffix(n, "_test.go") {
+				name := importName(rel)
+				candidates[name] = append(candidates[name], rel)
+				break
+			}
+		}
+		return nil
+	})
+
+	paths := make(map[string]string)
+	for name, list := range candidates {
+		base := list[0]
+		for _, path := range list {
+			if len(path) < len(base) {
+				base = path
+			}
+		}
+		unique := true
+		for _, path := range list {
+			unique = unique && (path == base || importName(path) == importName(base) && pathpkg.Dir(path) == base)
+		}
+		if unique {
+			paths[name] = base
+		}
+	}
+	return paths
+})
+
+// importName returns the name assumed for the package with an import path:
+// its last element, without a major version suffix, a "go-" prefix or
+// anything from the first character that cannot start an identifier.
+func importName(path string) string {
+	base := pathpkg.Base(path)
+	if len(base) > 1 && base[0] == 'v' && strings.Trim(base[1:], "0123456789") == "" && pathpkg.Dir(path) != "." {
+		base = pathpkg.Base(pathpkg.Dir(path))
+	}
+	base = strings.TrimPrefix(base, "go-")
+	if i := strings.IndexFunc(base, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// ParseImport parses the import of a package for replacement code: an import
+// path, or a package name and an import path separated by "=" or space.
+func ParseImport(s string) (name, path string) {
+	s = strings.TrimSpace(s)
+	if name, path, ok := strings.Cut(s, "="); ok {
+		return strings.TrimSpace(name), strings.Trim(strings.TrimSpace(path), `"`)
+	}
+	if fields := strings.Fields(s); len(fields) == 2 {
+		return fields[0], strings.Trim(fields[1], `"`)
+	}
+	path = strings.Trim(s, `"`)
+	return importName(path), path
+}
+
+// isStdImport reports whether path is that of a standard library package,
+// whose first element has no dot.
+func isStdImport(path string) bool {
+	first, _, _ := strings.Cut(path, "/")
+	return !strings.Contains(first, ".")
+}
+
+// Qualifiers returns the package names the replacement qualifies identifiers
+// with, such as os in os.ReadFile, each once, in order of first use.
+// Metavariables are not qualifiers.
+func (r *Replacement) Qualifiers() []string {
+	var names []string
+	seen := make(map[string]bool)
+	scanQualifiers(r.Text, func(offset int, name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	})
+	return names
+}
+
+// scanQualifiers calls f for each identifier of code that is followed by a
+// dot and is not itself selected from something.
+func scanQualifiers(code string, f func(offset int, name string)) {
+	src := []byte(code)
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, 0)
+	prev := token.ILLEGAL
+	var ident string
+	identOffset := -1
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.PERIOD && identOffset >= 0 && !IsMetavariable(ident) {
+			f(identOffset, ident)
+		}
+		identOffset = -1
+		if tok == token.IDENT && prev != token.PERIOD {
+			ident, identOffset = lit, file.Offset(pos)
+		}
+		if tok == token.EOF {
+			return
+		}
+		prev = tok
+	}
+}
+
+// withQualifiers returns the replacement with the qualifiers renamed by
+// renames.
+func (r *Replacement) withQualifiers(renames map[string]string) *Replacement {
+	var sb strings.Builder
+	last := 0
+	scanQualifiers(r.Text, func(offset int, name string) {
+		if to, ok := renames[name]; ok {
+			sb.WriteString(r.Text[last:offset])
+			sb.WriteString(to)
+			last = offset + len(name)
+		}
+	})
+	sb.WriteString(r.Text[last:])
+	renamed := NewReplacement(sb.String())
+	renamed.Imports = r.Imports
+	return renamed
+}
+
+// planImports returns the replacement to use in the file with contents, with
+// qualifiers renamed to the aliases under which the file imports their
+// packages, and the plan for adding and removing imports. The plan is nil
+// when the replacement qualifies nothing or the file does not parse.
+func planImports(contents []byte, r *Replacement) (*Replacement, *importPlan) {
+	qualifiers := r.Qualifiers()
+	if len(qualifiers) == 0 {
+		return r, nil
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "", contents, 0)
+	if err != nil {
+		return r, nil
+	}
+
+	byName, byPath := make(map[string]bool), make(map[string]string)
+	for _, imp := range fileImports(f) {
+		byName[imp.name] = true
+		if imp.name != "_" && imp.name != "." {
+			byPath[imp.path] = imp.name
+		}
+	}
+	plan := &importPlan{add: make(map[string]string), used: usedQualifiers(f)}
+	renames := make(map[string]string)
+	for _, q := range qualifiers {
+		if byName[q] {
+			continue
+		}
+		path, ok := r.Imports[q]
+		if !ok {
+			path = stdImports()[q]
+		}
+		if path == "" {
+			continue // most likely a variable
+		}
+		if alias, ok := byPath[path]; ok {
+			renames[q] = alias
+		} else {
+			plan.add[q] = path
+		}
+	}
+	if len(renames) > 0 {
+		r = r.withQualifiers(renames)
+	}
+	return r, plan
+}
+
+// fileImports returns the imports of f.
+func fileImports(f *ast.File) []fileImport {
+	var imports []fileImport
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			spec := spec.(*ast.ImportSpec)
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			name := importName(path)
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			imports = append(imports, fileImport{name: name, path: path, spec: spec, decl: gen})
+		}
+	}
+	return imports
+}
+
+// usedQualifiers returns the names of the identifiers that f selects from
+// without declaring them: the packages it refers to, and possibly variables
+// declared in other files of its package.
+func usedQualifiers(f *ast.File) map[string]bool {
+	used := make(map[string]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && id.Obj == nil {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+	return used
+}
+
+// fix returns contents, the rewritten file, with the imports the replacement
+// code needs added and those it made unused removed. Imports are edited in
+// place, keeping their grouping and comments. Contents that do not parse are
+// returned unchanged.
+func (p *importPlan) fix(contents []byte) []byte {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", contents, parser.ParseComments)
+	if err != nil {
+		return contents
+	}
+	used := usedQualifiers(f)
+
+	// Remove the imports the rewrite made unused, and the declarations left
+	// empty, last first
+	var removals [][2]int
+	imports := fileImports(f)
+	unused := make(map[*ast.GenDecl][]fileImport)
+	var decls []*ast.GenDecl
+	for _, imp := range imports {
+		if imp.name == "_" || imp.name == "." || imp.path == "C" || !p.used[imp.name] || used[imp.name] {
+			continue
+		}
+		if unused[imp.decl] == nil {
+			decls = append(decls, imp.decl)
+		}
+		unused[imp.decl] = append(unused[imp.decl], imp)
+	}
+	for _, decl := range decls {
+		if len(unused[decl]) == len(decl.Specs) {
+			removals = append(removals, lineRange(fset, contents, decl.Pos(), decl.End()))
+			continue
+		}
+		for _, imp := range unused[decl] {
+			end := imp.spec.End()
+			if imp.spec.Comment != nil {
+				end = imp.spec.Comment.End()
+			}
+			removals = append(removals, lineRange(fset, contents, imp.spec.Pos(), end))
+		}
+	}
+	sort.Slice(removals, func(i, j int) bool { return removals[i][0] > removals[j][0] })
+	for _, r := range removals {
+		contents = append(contents[:r[0]:r[0]], contents[r[1]:]...)
+	}
+
+	// Add the imports the replacement code needs
+	names := make([]string, 0, len(p.add))
+	for name := range p.add {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !used[name] {
+			continue
+		}
+		contents = addImport(contents, name, p.add[name])
+	}
+	return contents
+}
+
+// lineRange returns the byte offsets of the code from pos to end, widened to
+// whole lines if nothing else shares them.
+func lineRange(fset *token.FileSet, contents []byte, pos, end token.Pos) [2]int {
+	start, stop := fset.Position(pos).Offset, fset.Position(end).Offset
+	lineStart := bytes.LastIndexByte(contents[:start], '\n') + 1
+	lineEnd := len(contents)
+	if i := bytes.IndexByte(contents[stop:], '\n'); i >= 0 {
+		lineEnd = stop + i + 1
+	}
+	if len(bytes.TrimSpace(contents[lineStart:start])) == 0 && len(bytes.TrimSpace(contents[stop:lineEnd])) == 0 {
+		return [2]int{lineStart, lineEnd}
+	}
+	return [2]int{start, stop}
+}
+
+// addImport returns contents with an import of path under name. It joins the
+// group of imports, standard library or not, that path belongs to, in sorted
+// position, or starts one.
+func addImport(contents []byte, name, path string) []byte {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", contents, parser.ImportsOnly)
+	if err != nil {
+		return contents
+	}
+	spec := strconv.Quote(path)
+	if name != importName(path) {
+		spec = name + " " + spec
+	}
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+	insert := func(at int, text string) []byte {
+		return append(contents[:at:at], append([]byte(text), contents[at:]...)...)
+	}
+
+	var decl *ast.GenDecl
+	for _, d := range f.Decls {
+		if gen, ok := d.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			if decl == nil || !decl.Lparen.IsValid() && gen.Lparen.IsValid() {
+				decl = gen
+			}
+		}
+	}
+	if decl == nil {
+		// After the package clause
+		end := offset(f.Name.End())
+		if i := bytes.IndexByte(contents[end:], '\n'); i >= 0 {
+			return insert(end+i+1, "\nimport "+spec+"\n")
+		}
+		return append(contents, "\n\nimport "+spec+"\n"...)
+	}
+	if !decl.Lparen.IsValid() {
+		// Turn import "x" into a block
+		old := decl.Specs[0].(*ast.ImportSpec)
+		oldPath, _ := strconv.Unquote(old.Path.Value)
+		specs := []string{string(contents[offset(old.Pos()):offset(old.End())]), spec}
+		if path < oldPath && isStdImport(path) == isStdImport(oldPath) || isStdImport(path) && !isStdImport(oldPath) {
+			specs[0], specs[1] = specs[1], specs[0]
+		}
+		sep := "\n\t"
+		if isStdImport(path) != isStdImport(oldPath) {
+			sep = "\n\n\t"
+		}
+		block := "(\n\t" + specs[0] + sep + specs[1] + "\n)"
+		return append(contents[:offset(old.Pos()):offset(old.Pos())], append([]byte(block), contents[offset(old.End()):]...)...)
+	}
+
+	// Groups are runs of specs without blank lines between them
+	var groups [][]*ast.ImportSpec
+	lastLine := 0
+	for _, s := range decl.Specs {
+		s := s.(*ast.ImportSpec)
+		if line := fset.Position(s.Pos()).Line; len(groups) == 0 || line > lastLine+1 {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], s)
+		lastLine = fset.Position(s.End()).Line
+	}
+	specPath := func(s *ast.ImportSpec) string {
+		p, _ := strconv.Unquote(s.Path.Value)
+		return p
+	}
+	lineStart := func(pos token.Pos) int {
+		at := offset(pos)
+		return bytes.LastIndexByte(contents[:at], '\n') + 1
+	}
+	lineAfter := func(pos token.Pos) int {
+		at := offset(pos)
+		if i := bytes.IndexByte(contents[at:], '\n'); i >= 0 {
+			return at + i + 1
+		}
+		return len(contents)
+	}
+	indent := "\t"
+	if len(decl.Specs) > 0 {
+		at := lineStart(decl.Specs[0].Pos())
+		indent = string(contents[at:offset(decl.Specs[0].Pos())])
+	}
+
+	for _, group := range groups {
+		if isStdImport(specPath(group[0])) != isStdImport(path) {
+			continue
+		}
+		for _, s := range group {
+			if specPath(s) > path {
+				return insert(lineStart(s.Pos()), indent+spec+"\n")
+			}
+		}
+		return insert(lineAfter(group[len(group)-1].End()), indent+spec+"\n")
+	}
+	if len(groups) == 0 {
+		at := offset(decl.Rparen)
+		if at > 0 && contents[at-1] != '\n' {
+			return insert(at, "\n"+indent+spec+"\n")
+		}
+		return insert(at, indent+spec+"\n")
+	}
+	if isStdImport(path) {
+		return insert(lineStart(groups[0][0].Pos()), indent+spec+"\n\n")
+	}
+	last := groups[len(groups)-1]
+	return insert(lineAfter(last[len(last)-1].End()), "\n"+indent+spec+"\n")
+}
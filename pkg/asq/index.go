@@ -0,0 +1,385 @@
+package asq
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// DefaultIndexDir is the cache directory used when no other is configured.
+const DefaultIndexDir = ".asq-cache"
+
+// indexMarker names the file that identifies a directory as an asq index.
+// Clear refuses to touch a directory without it.
+const indexMarker = "asq-index"
+
+// indexIgnore is the .gitignore OpenIndex writes into the index directory.
+const indexIgnore = "*\n"
+
+// ErrNotIndex is returned when clearing a directory that holds no asq index.
+var ErrNotIndex = errors.New("not an asq index")
+
+// indexVersion is bumped whenever the layout of IndexEntry changes, so stale
+// entries written by older builds are ignored rather than misread.
+const indexVersion = 1
+
+// IndexEntry holds the precomputed data for a single source file.
+type IndexEntry struct {
+	Version int      `json:"version"`
+	Path    string   `json:"path"`
+	Size    int64    `json:"size"`
+	ModTime int64    `json:"mtime"`
+	Hash    string   `json:"hash"`
+	Kinds   []string `json:"kinds"` // sorted set of named tree-sitter node types in the file
+}
+
+// Index is a persistent on-disk cache of per-file data, keyed by file path and
+// validated by size, modification time and content hash. Every entry is its own
+// file and is replaced atomically, so several asq processes may share an index.
+type Index struct {
+	Dir string
+}
+
+// OpenIndex opens the index stored in dir, creating the directory if needed.
+func OpenIndex(dir string) (*Index, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create index directory: %v", err)
+	}
+	marker := filepath.Join(dir, indexMarker)
+	if _, err := os.Stat(marker); errors.Is(err, fs.ErrNotExist) {
+		if err := writeFileAtomic(marker, []byte(fmt.Sprintf("%d\n", indexVersion)), 0o644); err != nil {
+			return nil, fmt.Errorf("failed to create index directory: %v", err)
+		}
+	}
+	// Keep the cache out of version control without touching the user's .gitignore
+	ignore := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(ignore); errors.Is(err, fs.ErrNotExist) {
+		_ = writeFileAtomic(ignore, []byte(indexIgnore), 0o600)
+	}
+	return &Index{Dir: dir}, nil
+}
+
+// entryPath returns the location of the entry for the given source file.
+func (ix *Index) entryPath(path string) string {
+	sum := sha256.Sum256([]byte(indexKey(path)))
+	return filepath.Join(ix.Dir, "files", hex.EncodeToString(sum[:16])+".json")
+}
+
+// indexKey normalizes a path so that "./a.go" and "a.go" share an entry.
+func indexKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// Load returns the stored entry for path, or nil if there is none.
+// Unreadable or outdated entries are treated as missing.
+func (ix *Index) Load(path string) *IndexEntry {
+	data, err := os.ReadFile(ix.entryPath(path))
+	if err != nil {
+		return nil
+	}
+	var entry IndexEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil
+	}
+	if entry.Version != indexVersion || entry.Path != indexKey(path) {
+		return nil
+	}
+	return &entry
+}
+
+// Store writes the entry to the index.
+func (ix *Index) Store(entry *IndexEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(ix.Dir, "files"), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(ix.entryPath(entry.Path), data, 0o600)
+}
+
+// IsFresh reports whether the entry still describes the file with the given info
+// without reading the file's contents.
+func (e *IndexEntry) IsFresh(info fs.FileInfo) bool {
+	return e.Size == info.Size() && e.ModTime == info.ModTime().UnixNano()
+}
+
+// Refresh returns an up to date entry for path. Unchanged files are answered from
+// the index without being read; files whose size or mtime changed are re-read and
+// only re-parsed when their content hash differs. The boolean result reports
+// whether the index had to be updated.
+func (ix *Index) Refresh(path string, info fs.FileInfo) (*IndexEntry, bool, error) {
+	entry := ix.Load(path)
+	if entry != nil && entry.IsFresh(info) {
+		return entry, false, nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read file: %v", err)
+	}
+
+	hash := contentHash(contents)
+	if entry != nil && entry.Hash == hash {
+		// Touched but unchanged: only the stat data needs updating
+		entry.Size = info.Size()
+		entry.ModTime = info.ModTime().UnixNano()
+	} else {
+		entry, err = BuildIndexEntry(path, info, contents)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if err := ix.Store(entry); err != nil {
+		return nil, false, fmt.Errorf("failed to write index entry: %v", err)
+	}
+	return entry, true, nil
+}
+
+// Entries returns every entry stored in the index.
+func (ix *Index) Entries() ([]*IndexEntry, error) {
+	files, err := os.ReadDir(filepath.Join(ix.Dir, "files"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []*IndexEntry
+	for _, f := range files {
+		if filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(ix.Dir, "files", f.Name()))
+		if err != nil {
+			continue // removed by a concurrent writer
+		}
+		var entry IndexEntry
+		if json.Unmarshal(data, &entry) == nil && entry.Version == indexVersion {
+			entries = append(entries, &entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// Clear removes the entries of the index and the files OpenIndex created,
+// then the index directory itself if nothing else is left in it. It returns
+// ErrNotIndex, without removing anything, if the directory exists but has no
+// index marker, so a mistyped --cache-dir cannot delete unrelated files.
+func (ix *Index) Clear() error {
+	if _, err := os.Stat(filepath.Join(ix.Dir, indexMarker)); err != nil {
+		if _, derr := os.Stat(ix.Dir); errors.Is(derr, fs.ErrNotExist) {
+			return nil // nothing to clear
+		}
+		return fmt.Errorf("%w: %s has no %s file", ErrNotIndex, ix.Dir, indexMarker)
+	}
+	if err := os.RemoveAll(filepath.Join(ix.Dir, "files")); err != nil {
+		return err
+	}
+	ignore := filepath.Join(ix.Dir, ".gitignore")
+	if data, err := os.ReadFile(ignore); err == nil && string(data) == indexIgnore {
+		if err := os.Remove(ignore); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(filepath.Join(ix.Dir, indexMarker)); err != nil {
+		return err
+	}
+	// Fails, leaving the directory in place, if it holds anything else
+	_ = os.Remove(ix.Dir)
+	return nil
+}
+
+// BuildIndexEntry parses contents and computes a fresh entry for path.
+func BuildIndexEntry(path string, info fs.FileInfo, contents []byte) (*IndexEntry, error) {
+	lang, err := GetTSLanguageFromEnry(path, contents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get language: %v", err)
+	}
+
+	parser := sitter.NewParser()
+	parser.SetLanguage(lang)
+	tree := parser.Parse(nil, contents)
+	defer tree.Close()
+
+	kinds := make(map[string]struct{})
+	cursor := sitter.NewTreeCursor(tree.RootNode())
+	defer cursor.Close()
+	for done := false; !done; {
+		if node := cursor.CurrentNode(); node.IsNamed() {
+			kinds[node.Type()] = struct{}{}
+		}
+		if cursor.GoToFirstChild() {
+			continue
+		}
+		for !cursor.GoToNextSibling() {
+			if !cursor.GoToParent() {
+				done = true
+				break
+			}
+		}
+	}
+
+	entry := &IndexEntry{
+		Version: indexVersion,
+		Path:    indexKey(path),
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Hash:    contentHash(contents),
+		Kinds:   make([]string, 0, len(kinds)),
+	}
+	for kind := range kinds {
+		entry.Kinds = append(entry.Kinds, kind)
+	}
+	sort.Strings(entry.Kinds)
+	return entry, nil
+}
+
+// MayMatch reports whether a query requiring the given node kinds could match
+// the indexed file. It returns false only when a required kind is absent.
+func (e *IndexEntry) MayMatch(kinds []string) bool {
+	for _, kind := range kinds {
+		i := sort.SearchStrings(e.Kinds, kind)
+		if i == len(e.Kinds) || e.Kinds[i] != kind {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryKinds returns the named node types a tree-sitter query requires.
+// Every one of them must be present in a file for the query to match it. The
+// wildcard node (_), the alternatives of an alternation [...] and nodes
+// quantified with ? or * are not required, and neither are the nodes inside
+// them.
+func QueryKinds(query string) []string {
+	type group struct {
+		alternation bool
+		kinds       []string // required kinds of the group and its children
+	}
+	query = stripQueryStrings(query)
+	stack := []*group{{}}
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; c {
+		case ';':
+			// A comment runs to the end of the line
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case '(', '[':
+			g := &group{alternation: c == '['}
+			if c == '(' {
+				j := i + 1
+				for j < len(query) && isQueryKindByte(query[j]) {
+					j++
+				}
+				if kind := query[i+1 : j]; kind != "" && kind != "_" {
+					g.kinds = append(g.kinds, kind)
+				}
+			}
+			stack = append(stack, g)
+		case ')', ']':
+			if len(stack) == 1 {
+				continue // unbalanced; the query will fail to compile anyway
+			}
+			g := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			j := i + 1
+			for j < len(query) && (query[j] == ' ' || query[j] == '\t' || query[j] == '\n' || query[j] == '\r') {
+				j++
+			}
+			optional := j < len(query) && (query[j] == '?' || query[j] == '*')
+			if !g.alternation && !optional {
+				parent := stack[len(stack)-1]
+				parent.kinds = append(parent.kinds, g.kinds...)
+			}
+		}
+	}
+
+	seen := make(map[string]struct{})
+	var kinds []string
+	for _, g := range stack {
+		for _, kind := range g.kinds {
+			if _, ok := seen[kind]; !ok {
+				seen[kind] = struct{}{}
+				kinds = append(kinds, kind)
+			}
+		}
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func isQueryKindByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c == '_'
+}
+
+// stripQueryStrings blanks out string literals in a query so that their
+// contents are not mistaken for node types.
+func stripQueryStrings(query string) string {
+	var sb strings.Builder
+	inString := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case inString && c == '\\' && i+1 < len(query):
+			i++
+			continue
+		case c == '"':
+			inString = !inString
+		case inString:
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+func contentHash(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place with permissions perm, so concurrent readers never observe a
+// partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package asq_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+func TestQueryKinds(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{
+			name:     "nodes and predicates",
+			query:    `(call_expression function: (selector_expression operand: (identifier) @name (#eq? @name "(fake_kind") field: (field_identifier) @field (#eq? @field "Foo")) arguments: (argument_list)) @x`,
+			expected: []string{"argument_list", "call_expression", "field_identifier", "identifier", "selector_expression"},
+		},
+		{
+			name:     "wildcard node",
+			query:    `(call_expression arguments: (argument_list (_) @_asq_X .)) @x`,
+			expected: []string{"argument_list", "call_expression"},
+		},
+		{
+			name:     "alternation",
+			query:    `(call_expression function: [(identifier) (selector_expression field: (field_identifier))]) @x`,
+			expected: []string{"call_expression"},
+		},
+		{
+			name:     "quantifiers",
+			query:    "(block (comment)? (if_statement (block))* (return_statement)+ ((expression_statement) (labeled_statement)) ?) @x ; (go_statement)",
+			expected: []string{"block", "return_statement"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := asq.QueryKinds(tt.query); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected:\n%v\nGot:\n%v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIndexRefresh(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-index-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, "a.go")
+	if err := os.WriteFile(file, []byte("package a\nvar x = 1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	index, err := asq.OpenIndex(filepath.Join(tmpDir, ".asq-cache"))
+	if err != nil {
+		t.Fatalf("Failed to open index: %v", err)
+	}
+
+	info, _ := os.Stat(file)
+	entry, updated, err := index.Refresh(file, info)
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if !updated {
+		t.Error("Expected first refresh to update the index")
+	}
+	if entry.MayMatch([]string{"call_expression"}) {
+		t.Error("Expected file without calls not to match a call_expression query")
+	}
+	if !entry.MayMatch([]string{"var_declaration", "identifier"}) {
+		t.Error("Expected file to match a var_declaration query")
+	}
+
+	// An unchanged file is answered from the index
+	if _, updated, _ = index.Refresh(file, info); updated {
+		t.Error("Expected unchanged file not to update the index")
+	}
+
+	// Touching the file only refreshes the stat data
+	later := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatalf("Failed to touch file: %v", err)
+	}
+	info, _ = os.Stat(file)
+	touched, updated, _ := index.Refresh(file, info)
+	if !updated || touched.Hash != entry.Hash {
+		t.Errorf("Expected touched file to keep its hash, got updated=%v", updated)
+	}
+
+	// Changed content is re-parsed
+	if err := os.WriteFile(file, []byte("package a\nvar x = f()\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	info, _ = os.Stat(file)
+	changed, _, err := index.Refresh(file, info)
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if !changed.MayMatch([]string{"call_expression"}) {
+		t.Error("Expected changed file to contain a call_expression")
+	}
+	if query := `(call_expression arguments: (argument_list (_) @_asq_X)) @x`; !changed.MayMatch(asq.QueryKinds(query)) {
+		t.Error("Expected changed file to match a query with a wildcard node")
+	}
+	if query := `[(call_expression) (go_statement)] @x`; !entry.MayMatch(asq.QueryKinds(query)) {
+		t.Error("Expected an alternation not to require its alternatives")
+	}
+
+	entries, err := index.Entries()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d (%v)", len(entries), err)
+	}
+
+	if err := index.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if index.Load(file) != nil {
+		t.Error("Expected no entry after Clear")
+	}
+}
+
+func TestIndexClear(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-index-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// A directory that is not an index is left alone
+	src := filepath.Join(tmpDir, "a.go")
+	if err := os.WriteFile(src, []byte("package a\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := (&asq.Index{Dir: tmpDir}).Clear(); !errors.Is(err, asq.ErrNotIndex) {
+		t.Errorf("Expected ErrNotIndex, got %v", err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Fatalf("Expected %s to survive Clear, got %v", src, err)
+	}
+
+	// An index sharing a directory with other files removes only its own
+	ignore := filepath.Join(tmpDir, ".gitignore")
+	if err := os.WriteFile(ignore, []byte("bin/\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	index, err := asq.OpenIndex(tmpDir)
+	if err != nil {
+		t.Fatalf("OpenIndex failed: %v", err)
+	}
+	info, _ := os.Stat(src)
+	if _, _, err := index.Refresh(src, info); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if err := index.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	for _, path := range []string{src, ignore} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to survive Clear, got %v", path, err)
+		}
+	}
+	for _, name := range []string{"files", "asq-index"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", name, err)
+		}
+	}
+
+	// An index of its own is removed entirely, and clearing it again is a no-op
+	dir := filepath.Join(tmpDir, asq.DefaultIndexDir)
+	if index, err = asq.OpenIndex(dir); err != nil {
+		t.Fatalf("OpenIndex failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := index.Clear(); err != nil {
+			t.Fatalf("Clear failed: %v", err)
+		}
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got %v", dir, err)
+	}
+}
@@ -0,0 +1,112 @@
+package asq
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// InlinePatternName names patterns given inline rather than in a file.
+const InlinePatternName = "inline"
+
+// InlinePatternSource wraps a pattern snippet, such as one given on the
+// command line, in a synthetic pattern file: a package holding the snippet
+// between //asq_start and //asq_end comments. The snippet is read as an
+// expression, a statement or a declaration, whichever parses first, and its
+// /***/ and _asq_ wildcards work as in pattern files. Of statements and
+// declarations only a return statement or a function declaration can be
+// searched for; others fail with ErrUnsupportedPattern.
+func InlinePatternSource(snippet string) ([]byte, error) {
+	snippet = strings.TrimSpace(snippet)
+	if snippet == "" {
+		return nil, fmt.Errorf("%w: empty pattern", ErrParse)
+	}
+	// The //line comments make errors point into the snippet
+	body := "package asq_inline\n\nfunc asq_inline() {\n\t//asq_start\n//line pattern:1:1\n" + snippet + "\n\t//asq_end\n}\n"
+	if _, err := parser.ParseExpr(snippet); err == nil {
+		return []byte(body), nil
+	}
+	file, stmtErr := parser.ParseFile(token.NewFileSet(), "", body, parser.SkipObjectResolution)
+	if stmtErr == nil {
+		stmts := file.Decls[0].(*ast.FuncDecl).Body.List
+		if len(stmts) != 1 {
+			return nil, unsupportedInlinePattern(fmt.Sprintf("has %d statements", len(stmts)))
+		}
+		switch stmt := stmts[0].(type) {
+		case *ast.ExprStmt, *ast.ReturnStmt:
+			return []byte(body), nil
+		default:
+			return nil, unsupportedInlinePattern("is " + inlineNodeDesc(stmt))
+		}
+	}
+	decl := "package asq_inline\n\n//asq_start\n//line pattern:1:1\n" + snippet + "\n//asq_end\n"
+	if file, err := parser.ParseFile(token.NewFileSet(), "", decl, parser.SkipObjectResolution); err == nil {
+		if len(file.Decls) != 1 {
+			return nil, unsupportedInlinePattern(fmt.Sprintf("has %d declarations", len(file.Decls)))
+		}
+		if _, ok := file.Decls[0].(*ast.FuncDecl); !ok {
+			return nil, unsupportedInlinePattern("is " + inlineNodeDesc(file.Decls[0]))
+		}
+		return []byte(decl), nil
+	}
+	return nil, fmt.Errorf("%w: pattern is not an expression, statements or a declaration: %v", ErrParse, stmtErr)
+}
+
+// unsupportedInlinePattern reports an inline pattern that parses but cannot
+// be searched for; what is, for instance, "is an assignment".
+func unsupportedInlinePattern(what string) error {
+	return fmt.Errorf("%w: the pattern %s, but an inline pattern can only be an expression, a return statement or a function declaration", ErrUnsupportedPattern, what)
+}
+
+// inlineNodeDesc describes a statement or declaration for errors, such as
+// "an assignment" or "an if statement".
+func inlineNodeDesc(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.AssignStmt:
+		return "an assignment"
+	case *ast.IncDecStmt:
+		if n.Tok == token.INC {
+			return "an increment"
+		}
+		return "a decrement"
+	case *ast.DeclStmt:
+		return inlineNodeDesc(n.Decl)
+	case *ast.GenDecl:
+		return withArticle(n.Tok.String() + " declaration")
+	case *ast.BranchStmt:
+		return withArticle(n.Tok.String() + " statement")
+	case *ast.BlockStmt:
+		return "a block"
+	case *ast.RangeStmt:
+		return "a for statement"
+	case *ast.TypeSwitchStmt:
+		return "a type switch statement"
+	}
+	kind := strings.TrimSuffix(strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast."), "Stmt")
+	return withArticle(strings.ToLower(kind) + " statement")
+}
+
+func withArticle(s string) string {
+	if strings.ContainsRune("aeiou", rune(s[0])) {
+		return "an " + s
+	}
+	return "a " + s
+}
+
+// ExtractInlineTreeSitterQuery is like ExtractTreeSitterQueryContext for a
+// pattern snippet given inline; see InlinePatternSource.
+func ExtractInlineTreeSitterQuery(ctx context.Context, snippet string) (string, error) {
+	src, err := InlinePatternSource(snippet)
+	if err != nil {
+		return "", err
+	}
+	return extractTreeSitterQuery(ctx, InlinePatternName+".go", src, false)
+}
+
+// InlinePatternInfo describes patterns given inline for reports.
+func InlinePatternInfo() PatternInfo {
+	return PatternInfo{Name: InlinePatternName, ID: InlinePatternName, Severity: SeverityWarning}
+}
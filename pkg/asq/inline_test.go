@@ -0,0 +1,79 @@
+package asq_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+func TestExtractInlineTreeSitterQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		snippet string
+		file    string // the equivalent pattern file
+	}{
+		{
+			name:    "expression",
+			snippet: "e.Inst().Foo()",
+			file:    "package p\n\nfunc q() {\n\t//asq_start\n\te.Inst().Foo()\n\t//asq_end\n}\n",
+		},
+		{
+			name:    "wildcards",
+			snippet: "/***/e.Inst()._asq_M()",
+			file:    "package p\n\nfunc q() {\n\t//asq_start\n\t/***/e.Inst()._asq_M()\n\t//asq_end\n}\n",
+		},
+		{
+			name:    "return statement",
+			snippet: "return",
+			file:    "package p\n\nfunc q() {\n\t//asq_start\n\treturn\n\t//asq_end\n}\n",
+		},
+		{
+			name:    "declaration",
+			snippet: "func Example() {\n\treturn\n}",
+			file:    "package p\n\n//asq_start\nfunc Example() {\n\treturn\n}\n//asq_end\n",
+		},
+	}
+
+	tmpDir, err := os.MkdirTemp("", "asq-inline-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(tmpDir, tt.name+".go")
+			if err := os.WriteFile(file, []byte(tt.file), 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+			expected, err := asq.ExtractTreeSitterQuery(file)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got, err := asq.ExtractInlineTreeSitterQuery(context.Background(), tt.snippet)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != expected {
+				t.Errorf("Expected query:\n%s\ngot:\n%s", expected, got)
+			}
+		})
+	}
+
+	for _, snippet := range []string{"", "x :=", "func {"} {
+		if _, err := asq.ExtractInlineTreeSitterQuery(context.Background(), snippet); !errors.Is(err, asq.ErrParse) {
+			t.Errorf("Expected ErrParse for %q, got %v", snippet, err)
+		}
+	}
+
+	// Snippets that parse but have no query
+	for _, snippet := range []string{"a := 1", "x = 1; y = 2", "f(x)\nreturn", "var e = 3", "if x { return }", "type T int", "func F() {}\nfunc G() {}"} {
+		if _, err := asq.ExtractInlineTreeSitterQuery(context.Background(), snippet); !errors.Is(err, asq.ErrUnsupportedPattern) {
+			t.Errorf("Expected ErrUnsupportedPattern for %q, got %v", snippet, err)
+		}
+	}
+}
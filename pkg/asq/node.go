@@ -1,11 +1,14 @@
 package asq
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/StCredZero/asq/pkg/slicex"
 	"go/ast"
+	"go/printer"
 	"go/token"
 	"io"
+	"strconv"
 )
 
 // BuildAsqNode converts an ast.Node to its corresponding asq.Node
@@ -22,6 +25,7 @@ func BuildAsqNode(node ast.Node, p *QueryContext) Node {
 			Args: slicex.Map(astObj.Args, func(arg ast.Expr) Expr {
 				return BuildAsqExpr(arg, p)
 			}),
+			BindArgs: p.bindArguments,
 		}
 		callExpr.exprNode()
 		return callExpr
@@ -225,6 +229,7 @@ func BuildAsqExpr(node ast.Node, p *QueryContext) Expr {
 			Args: slicex.Map(astObj.Args, func(arg ast.Expr) Expr {
 				return BuildAsqExpr(arg, p)
 			}),
+			BindArgs: p.bindArguments,
 		}
 	case *ast.BinaryExpr:
 		return &BinaryExpr{
@@ -254,6 +259,11 @@ type CallExpr struct {
 	Fun      Expr
 	Args     []Expr
 	Wildcard bool
+	// BindArgs matches the arguments one for one, for a rewrite: the call
+	// then only matches calls with as many arguments, each metavariable binds
+	// the argument at its position, and other arguments must be the same
+	// code. Without it the arguments are not matched at all.
+	BindArgs bool
 }
 
 func (c *CallExpr) exprNode() {}
@@ -265,7 +275,72 @@ func (c *CallExpr) WriteTreeSitterQuery(w io.Writer) error {
 	if err := c.Fun.WriteTreeSitterQuery(w); err != nil {
 		return err
 	}
-	_, err := w.Write([]byte(" arguments: (argument_list))"))
+	if !c.BindArgs {
+		_, err := w.Write([]byte(" arguments: (argument_list))"))
+		return err
+	}
+	if len(c.Args) == 0 {
+		_, err := w.Write([]byte(` arguments: (argument_list "(" . ")"))`))
+		return err
+	}
+
+	if _, err := w.Write([]byte(" arguments: (argument_list .")); err != nil {
+		return err
+	}
+	for _, arg := range c.Args {
+		if _, err := w.Write([]byte(" ")); err != nil {
+			return err
+		}
+		if err := writeBoundArgument(w, arg); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte(" .")); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte("))"))
+	return err
+}
+
+// writeBoundArgument writes the query of a call argument matched one for one.
+// Metavariables match and capture any expression, /***/ wildcards match any
+// expression, identifiers, calls and selectors match as they do elsewhere, and
+// other arguments match code with the same text.
+func writeBoundArgument(w io.Writer, arg Expr) error {
+	switch arg := arg.(type) {
+	case *Ident:
+		switch {
+		case arg.Wildcard && IsMetavariable(arg.Ast.Name):
+			_, err := fmt.Fprintf(w, "(_) @%s", arg.Ast.Name)
+			return err
+		case arg.Wildcard:
+			_, err := w.Write([]byte("(_)"))
+			return err
+		}
+		return arg.WriteTreeSitterQuery(w)
+	case *CallExpr, *SelectorExpr:
+		return arg.WriteTreeSitterQuery(w)
+	}
+
+	node := arg.AstNode()
+	if d, ok := arg.(*DefaultExpr); ok {
+		node = d.Node
+	}
+	var metavariable string
+	ast.Inspect(node, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && IsMetavariable(ident.Name) && metavariable == "" {
+			metavariable = ident.Name
+		}
+		return metavariable == ""
+	})
+	if metavariable != "" {
+		return fmt.Errorf("%w: %s can only be rewritten as a whole call argument, an identifier, a call or a selector", ErrUnsupportedPattern, metavariable)
+	}
+	var text bytes.Buffer
+	if err := printer.Fprint(&text, token.NewFileSet(), node); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "(_) @value (#eq? @value %s)", strconv.Quote(text.String()))
 	return err
 }
 
@@ -306,6 +381,11 @@ type Ident struct {
 func (i *Ident) exprNode() {}
 
 func (i *Ident) WriteTreeSitterQuery(w io.Writer) error {
+	if i.Wildcard && IsMetavariable(i.Ast.Name) {
+		// Capture metavariables under their own name for replacements
+		_, err := fmt.Fprintf(w, "(identifier) @%s", i.Ast.Name)
+		return err
+	}
 	if i.Wildcard {
 		_, err := w.Write([]byte("(identifier)"))
 		return err
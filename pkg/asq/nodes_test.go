@@ -7,6 +7,7 @@ import (
 	"go/parser"
 	"go/token"
 	"testing"
+	"time"
 )
 
 func TestNonIdentWildcardTagging(t *testing.T) {
@@ -582,3 +583,82 @@ type Point struct {
 		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
 	}
 }
+
+func TestWildcardIntervals(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+		dropped  int // intervals that made no identifier a wildcard
+	}{
+		{
+			// The first interval ends where the second comment starts, right
+			// after a. It used to end a byte short, leaving a out and looping
+			// forever over the interval.
+			name:     "adjacent comments",
+			code:     "/***/a/***/.b()",
+			expected: `(call_expression function: (selector_expression operand: (identifier) field: (field_identifier) @field (#eq? @field "b")) arguments: (argument_list)) @x`,
+			dropped:  1,
+		},
+		{
+			name:     "separated comments",
+			code:     "/***/a./***/b()",
+			expected: `(call_expression function: (selector_expression operand: (identifier) field: (field_identifier) @field (#eq? @field "b")) arguments: (argument_list)) @x`,
+			dropped:  1,
+		},
+		{
+			name:     "nested call",
+			code:     "/***/f(/***/x)",
+			expected: `(call_expression function: (identifier) arguments: (argument_list)) @x`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package test\nfunc main() {\n\t//asq_start\n\t" + tt.code + "\n\t//asq_end\n}\n"
+			file, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ParseComments)
+			if err != nil {
+				t.Fatalf("Failed to parse file: %v", err)
+			}
+			var expr ast.Expr
+			ast.Inspect(file, func(n ast.Node) bool {
+				if stmt, ok := n.(*ast.ExprStmt); ok {
+					expr = stmt.X
+					return false
+				}
+				return true
+			})
+			if expr == nil {
+				t.Fatal("Failed to find the pattern expression")
+			}
+
+			p, _, _ := asq.NewQueryContext(file)
+			done := make(chan string, 1)
+			go func() {
+				query, err := asq.ConvertToTreeSitterQuery(expr, p)
+				if err != nil {
+					query = "error: " + err.Error()
+				}
+				done <- query
+			}()
+			select {
+			case got := <-done:
+				if got != tt.expected {
+					t.Errorf("Expected:\n%s\nGot:\n%s", tt.expected, got)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Building the query did not finish")
+			}
+
+			dropped := 0
+			for _, interval := range p.Intervals() {
+				if interval.Ident == nil {
+					dropped++
+				}
+			}
+			if dropped != tt.dropped {
+				t.Errorf("Expected %d dropped intervals, got %d", tt.dropped, dropped)
+			}
+		})
+	}
+}
@@ -1,24 +1,91 @@
 package asq
 
 import (
+	"context"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 // ExtractTreeSitterQuery parses a Go file and extracts the code between //asq_start and //asq_end
 // comments, then converts it to a tree-sitter query.
 func ExtractTreeSitterQuery(filePath string) (string, error) {
+	return ExtractTreeSitterQueryContext(context.Background(), filePath)
+}
+
+// PatternName derives a pattern's name from the path of its query file, e.g.
+// "patterns/_asq_no_foo.go" is named "no_foo".
+func PatternName(filePath string) string {
+	name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	return strings.TrimPrefix(name, "_asq_")
+}
+
+// ExtractTreeSitterQueryContext is like ExtractTreeSitterQuery but returns
+// ctx.Err() if ctx is done before the query has been built.
+func ExtractTreeSitterQueryContext(ctx context.Context, filePath string) (string, error) {
+	return extractTreeSitterQuery(ctx, filePath, nil, false)
+}
+
+// ExtractRewriteQuery is like ExtractTreeSitterQueryContext but builds the
+// query for a rewrite: a call with a metavariable among its arguments matches
+// its arguments one for one, binding the metavariable to the argument at its
+// position, and so only matches calls with as many arguments. The query of
+// ExtractTreeSitterQueryContext leaves the arguments of calls unconstrained.
+func ExtractRewriteQuery(ctx context.Context, filePath string) (string, error) {
+	return extractTreeSitterQuery(ctx, filePath, nil, true)
+}
+
+// extractTreeSitterQuery builds the query of the pattern file at filePath,
+// or of src if it is not nil, binding the arguments of calls if
+// bindArguments is set.
+func extractTreeSitterQuery(ctx context.Context, filePath string, src []byte, bindArguments bool) (string, error) {
+	pattern, err := parsePattern(ctx, filePath, src)
+	if err != nil {
+		return "", err
+	}
+	pattern.queryContext.bindArguments = bindArguments
+	// Convert to tree-sitter query
+	return ConvertToTreeSitterQuery(pattern.node, pattern.queryContext)
+}
+
+// parsedPattern is a pattern file and the code of its //asq_start ...
+// //asq_end region.
+type parsedPattern struct {
+	fset             *token.FileSet
+	file             *ast.File
+	src              []byte
+	queryContext     *QueryContext
+	startPos, endPos token.Pos // the ends of the region
+	node             ast.Node  // the code in the region
+}
+
+// parsePattern parses the pattern file at filePath, or src if it is not nil.
+func parsePattern(ctx context.Context, filePath string, src []byte) (*parsedPattern, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if src == nil {
+		var err error
+		if src, err = os.ReadFile(filePath); err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+	}
 	fset := token.NewFileSet()
-	astFile, err := parser.ParseFile(fset, filePath, nil, parser.ParseComments)
+	astFile, err := parser.ParseFile(fset, filePath, src, parser.ParseComments)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse file: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrParse, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
 	queryContext, startPos, endPos := NewQueryContext(astFile)
 	if !startPos.IsValid() || !endPos.IsValid() {
-		return "", fmt.Errorf("could not find //asq_start and //asq_end comments")
+		return nil, fmt.Errorf("could not find //asq_start and //asq_end comments")
 	}
 
 	// Extract the AST nodes between the comments
@@ -41,9 +108,15 @@ func ExtractTreeSitterQuery(filePath string) (string, error) {
 	})
 
 	if foundNode == nil {
-		return "", fmt.Errorf("no node found between comments")
+		return nil, fmt.Errorf("no node found between comments")
 	}
-
-	// Convert to tree-sitter query
-	return ConvertToTreeSitterQuery(foundNode, queryContext)
+	return &parsedPattern{
+		fset:         fset,
+		file:         astFile,
+		src:          src,
+		queryContext: queryContext,
+		startPos:     startPos,
+		endPos:       endPos,
+		node:         foundNode,
+	}, nil
 }
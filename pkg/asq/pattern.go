@@ -0,0 +1,80 @@
+package asq
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Severities accepted by //asq:severity, named after SARIF result levels.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNote    = "note"
+)
+
+// PatternInfo describes a pattern file for reports. It is read from
+// comments of the form
+//
+//	//asq:id no-inst-foo
+//	//asq:message Call Foo through the interface instead.
+//	//asq:severity error
+//
+// anywhere in the file. A message may span several //asq:message lines.
+type PatternInfo struct {
+	// Name is the PatternName of the file.
+	Name string
+	// ID identifies the pattern in reports. It defaults to Name.
+	ID string
+	// Message explains a match to the reader. It may be empty.
+	Message string
+	// Severity is one of SeverityError, SeverityWarning or SeverityNote.
+	// It defaults to SeverityWarning.
+	Severity string
+}
+
+// LoadPatternInfo reads the //asq: metadata comments of a pattern file.
+func LoadPatternInfo(filePath string) (PatternInfo, error) {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return PatternInfo{}, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	info := PatternInfo{Name: PatternName(filePath)}
+	var message []string
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "//asq:") {
+			continue
+		}
+		key, value, _ := strings.Cut(strings.TrimPrefix(line, "//asq:"), " ")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "id":
+			info.ID = value
+		case "message":
+			message = append(message, value)
+		case "severity":
+			info.Severity = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return PatternInfo{}, err
+	}
+
+	info.Message = strings.Join(message, " ")
+	if info.ID == "" {
+		info.ID = info.Name
+	}
+	switch info.Severity {
+	case "":
+		info.Severity = SeverityWarning
+	case SeverityError, SeverityWarning, SeverityNote:
+	default:
+		return PatternInfo{}, fmt.Errorf("%s: invalid //asq:severity %q (expected error, warning or note)", filePath, info.Severity)
+	}
+	return info, nil
+}
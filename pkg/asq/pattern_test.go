@@ -0,0 +1,73 @@
+package asq_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+func TestLoadPatternInfo(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-pattern-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name     string
+		contents string
+		expected asq.PatternInfo
+		wantErr  bool
+	}{
+		{
+			name: "metadata",
+			contents: `package example
+
+//asq:id no-inst-foo
+//asq:message Call Foo through the
+//asq:message interface instead.
+//asq:severity error
+func example() {
+	//asq_start
+	e.Inst().Foo()
+	//asq_end
+}
+`,
+			expected: asq.PatternInfo{Name: "metadata", ID: "no-inst-foo", Message: "Call Foo through the interface instead.", Severity: "error"},
+		},
+		{
+			name:     "defaults",
+			contents: "package example\n",
+			expected: asq.PatternInfo{Name: "defaults", ID: "defaults", Severity: "warning"},
+		},
+		{
+			name:     "invalid_severity",
+			contents: "package example\n\n//asq:severity fatal\n",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(tmpDir, "_asq_"+tt.name+".go")
+			if err := os.WriteFile(file, []byte(tt.contents), 0644); err != nil {
+				t.Fatalf("Failed to write pattern file: %v", err)
+			}
+			info, err := asq.LoadPatternInfo(file)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", info)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if info != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, info)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package asq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// ErrNoFixtures is reported for a pattern that has no fixture files to test
+// it against.
+var ErrNoFixtures = errors.New("no fixture files")
+
+// PatternTest is the result of testing a pattern file against its fixtures.
+// The fixtures of a pattern _asq_<name>.go are the file <name>.go next to it
+// and the Go files below a directory <name> next to it.
+type PatternTest struct {
+	Pattern  string // path of the pattern file
+	Name     string // name of the pattern, as given by PatternName
+	Fixtures []*FixtureTest
+	// Err records why the pattern could not be tested.
+	Err error
+}
+
+// Passed reports whether the pattern matched exactly the expected lines of
+// every fixture.
+func (t *PatternTest) Passed() bool {
+	if t.Err != nil {
+		return false
+	}
+	for _, f := range t.Fixtures {
+		if !f.Passed() {
+			return false
+		}
+	}
+	return true
+}
+
+// FixtureTest compares the lines where a pattern matches in a fixture file
+// with the lines annotated with // asq:expect or // asq:expect-not. An
+// annotation after code applies to its own line, and an annotation on a line
+// of its own to the next line with code. It may be followed by pattern names,
+// separated by commas, to apply only to those patterns. Every match must start
+// on a line annotated with // asq:expect; // asq:expect-not documents lines
+// that must not match.
+type FixtureTest struct {
+	File      string
+	Expected  []int // lines annotated with // asq:expect
+	ExpectNot []int // lines annotated with // asq:expect-not
+	Matched   []int // lines where a match starts
+	// Missing holds the expected lines without a match, and Unexpected the
+	// matched lines that were not expected.
+	Missing    []int
+	Unexpected []int
+	// Err records why the fixture could not be searched.
+	Err error
+
+	lines []string
+}
+
+// Passed reports whether the fixture matched exactly on its expected lines.
+func (f *FixtureTest) Passed() bool {
+	return f.Err == nil && len(f.Missing) == 0 && len(f.Unexpected) == 0
+}
+
+// Diff lists in line order the lines where the matches differ from the
+// annotations: missing matches prefixed with "-", unexpected ones with "+".
+func (f *FixtureTest) Diff() string {
+	var sb strings.Builder
+	lines := append(slices.Clone(f.Missing), f.Unexpected...)
+	slices.Sort(lines)
+	for _, line := range lines {
+		prefix := "+"
+		if slices.Contains(f.Missing, line) {
+			prefix = "-"
+		}
+		text := ""
+		if line <= len(f.lines) {
+			text = f.lines[line-1]
+		}
+		fmt.Fprintf(&sb, "%s%4d | %s\n", prefix, line, text)
+	}
+	return sb.String()
+}
+
+// RunPatternTests tests every pattern file below dir against its fixtures,
+// in lexical order. It returns an error only if dir cannot be walked.
+func RunPatternTests(ctx context.Context, dir string) ([]*PatternTest, error) {
+	var patterns []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && vcsDirs[d.Name()] {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && strings.HasPrefix(d.Name(), "_asq_") && filepath.Ext(path) == ".go" {
+			patterns = append(patterns, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var tests []*PatternTest
+	for _, pattern := range patterns {
+		if err := ctx.Err(); err != nil {
+			return tests, err
+		}
+		tests = append(tests, RunPatternTest(ctx, pattern))
+	}
+	return tests, nil
+}
+
+// RunPatternTest tests the pattern file at path against its fixtures.
+func RunPatternTest(ctx context.Context, path string) *PatternTest {
+	t := &PatternTest{Pattern: path, Name: PatternName(path)}
+	query, err := ExtractTreeSitterQueryContext(ctx, path)
+	if err != nil {
+		t.Err = fmt.Errorf("generating query: %w", err)
+		return t
+	}
+	fixtures, err := patternFixtures(path)
+	if err != nil {
+		t.Err = err
+		return t
+	}
+	if len(fixtures) == 0 {
+		t.Err = fmt.Errorf("%w: expected %s.go or a %s directory next to the pattern", ErrNoFixtures, t.Name, t.Name)
+		return t
+	}
+	for _, fixture := range fixtures {
+		t.Fixtures = append(t.Fixtures, testFixture(ctx, fixture, t.Name, query))
+	}
+	return t
+}
+
+// patternFixtures returns the fixture files of the pattern file at path.
+func patternFixtures(path string) ([]string, error) {
+	base := filepath.Join(filepath.Dir(path), PatternName(path))
+	var fixtures []string
+	if info, err := os.Stat(base + ".go"); err == nil && !info.IsDir() {
+		fixtures = append(fixtures, base+".go")
+	}
+	info, err := os.Stat(base)
+	if err != nil || !info.IsDir() {
+		return fixtures, nil
+	}
+	err = filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".go" && !strings.HasPrefix(d.Name(), "_asq_") {
+			fixtures = append(fixtures, path)
+		}
+		return nil
+	})
+	return fixtures, err
+}
+
+// testFixture runs query over the fixture file and compares its matches with
+// the annotations for the pattern name.
+func testFixture(ctx context.Context, file, name, query string) *FixtureTest {
+	f := &FixtureTest{File: file}
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		f.Err = err
+		return f
+	}
+	f.lines = strings.Split(string(contents), "\n")
+	if f.Expected, f.ExpectNot, err = parseExpectations(file, contents, name); err != nil {
+		f.Err = err
+		return f
+	}
+	for match, err := range MatchFile(ctx, file, query) {
+		if err != nil {
+			f.Err = err
+			return f
+		}
+		if !slices.Contains(f.Matched, match.Row) {
+			f.Matched = append(f.Matched, match.Row)
+		}
+	}
+	slices.Sort(f.Matched)
+	for _, line := range f.Expected {
+		if !slices.Contains(f.Matched, line) {
+			f.Missing = append(f.Missing, line)
+		}
+	}
+	for _, line := range f.Matched {
+		if !slices.Contains(f.Expected, line) {
+			f.Unexpected = append(f.Unexpected, line)
+		}
+	}
+	return f
+}
+
+// parseExpectations returns the lines annotated with // asq:expect and
+// // asq:expect-not for the pattern name in the Go source src.
+func parseExpectations(file string, src []byte, name string) (expect, expectNot []int, err error) {
+	fset := token.NewFileSet()
+	tf := fset.AddFile(file, -1, len(src))
+	var errs scanner.ErrorList
+	var s scanner.Scanner
+	s.Init(tf, src, errs.Add, scanner.ScanComments)
+
+	var pending []*[]int // annotations waiting for the next line with code
+	lastLine := 0        // the last line with code
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		line := tf.Line(pos)
+		if tok != token.COMMENT {
+			if tok == token.SEMICOLON && lit == "\n" {
+				continue // inserted at the end of a line
+			}
+			for _, lines := range pending {
+				*lines = append(*lines, line)
+			}
+			pending = nil
+			lastLine = line
+			continue
+		}
+
+		text := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(lit, "//"), "/*"), "*/"))
+		directive, names, _ := strings.Cut(text, " ")
+		var lines *[]int
+		switch directive {
+		case "asq:expect":
+			lines = &expect
+		case "asq:expect-not":
+			lines = &expectNot
+		default:
+			continue
+		}
+		if names = strings.TrimSpace(names); names != "" && !slices.Contains(strings.Split(names, ","), name) {
+			continue
+		}
+		if line == lastLine {
+			*lines = append(*lines, line)
+		} else {
+			pending = append(pending, lines)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, fmt.Errorf("%w: %v", ErrParse, errs.Err())
+	}
+	return expect, expectNot, nil
+}
+
+// CheckPatterns runs the pattern tests below dir as subtests of t, one per
+// pattern, and fails those whose matches differ from the annotations of their
+// fixtures. A package keeping its patterns in testdata can test them with
+//
+//	func TestPatterns(t *testing.T) {
+//		asq.CheckPatterns(t, "testdata")
+//	}
+func CheckPatterns(t *testing.T, dir string) {
+	t.Helper()
+	tests, err := RunPatternTests(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("Failed to find patterns: %v", err)
+	}
+	if len(tests) == 0 {
+		t.Fatalf("No pattern files below %s", dir)
+	}
+	for _, pt := range tests {
+		t.Run(pt.Name, func(t *testing.T) {
+			if pt.Err != nil {
+				t.Fatalf("%s: %v", pt.Pattern, pt.Err)
+			}
+			for _, f := range pt.Fixtures {
+				switch {
+				case f.Err != nil:
+					t.Errorf("%s: %v", f.File, f.Err)
+				case !f.Passed():
+					t.Errorf("%s: matches differ from the annotations (- missing, + unexpected):\n%s", f.File, f.Diff())
+				}
+			}
+		})
+	}
+}
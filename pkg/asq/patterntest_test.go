@@ -0,0 +1,96 @@
+package asq_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+func TestCheckPatterns(t *testing.T) {
+	asq.CheckPatterns(t, "testdata/patterns")
+}
+
+func TestRunPatternTests(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-patterntest-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		"_asq_calls.go": "package p\n\nfunc asq_calls() {\n\t//asq_start\n\tfmt.Println(/***/x)\n\t//asq_end\n}\n",
+		"calls.go": `package p
+
+func a() {
+	fmt.Println(1) // asq:expect
+	// asq:expect
+	// asq:expect-not other
+	fmt.Println(2)
+	// asq:expect-not
+	fmt.Println(3)
+	// asq:expect
+	x := 4
+	// asq:expect other
+	fmt.Println(5)
+}
+`,
+		"calls/b.go":     "package p\n\n/* asq:expect calls,other */\nvar _ = fmt.Println(1)\n",
+		"_asq_lonely.go": "package p\n\nfunc asq_lonely() {\n\t//asq_start\n\tfoo()\n\t//asq_end\n}\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	tests, err := asq.RunPatternTests(context.Background(), tmpDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tests) != 2 {
+		t.Fatalf("Expected 2 pattern tests, got %d", len(tests))
+	}
+
+	calls := tests[0]
+	if calls.Name != "calls" || calls.Err != nil || len(calls.Fixtures) != 2 {
+		t.Fatalf("Expected pattern calls with 2 fixtures, got %s with %d (%v)", calls.Name, len(calls.Fixtures), calls.Err)
+	}
+	if calls.Passed() {
+		t.Errorf("Expected pattern calls to fail")
+	}
+	f := calls.Fixtures[0]
+	for _, tt := range []struct {
+		name          string
+		got, expected []int
+	}{
+		{"expected", f.Expected, []int{4, 7, 11}},
+		{"expect-not", f.ExpectNot, []int{9}},
+		{"matched", f.Matched, []int{4, 7, 9, 13}},
+		{"missing", f.Missing, []int{11}},
+		{"unexpected", f.Unexpected, []int{9, 13}},
+	} {
+		if !reflect.DeepEqual(tt.got, tt.expected) {
+			t.Errorf("Expected %s lines %v, got %v", tt.name, tt.expected, tt.got)
+		}
+	}
+	expectedDiff := "+   9 | \tfmt.Println(3)\n-  11 | \tx := 4\n+  13 | \tfmt.Println(5)\n"
+	if diff := f.Diff(); diff != expectedDiff {
+		t.Errorf("Expected diff:\n%s\ngot:\n%s", expectedDiff, diff)
+	}
+	if f := calls.Fixtures[1]; f.File != filepath.Join(tmpDir, "calls", "b.go") || !f.Passed() {
+		t.Errorf("Expected calls/b.go to pass, got %s with missing %v and unexpected %v (%v)", f.File, f.Missing, f.Unexpected, f.Err)
+	}
+
+	if lonely := tests[1]; !errors.Is(lonely.Err, asq.ErrNoFixtures) || lonely.Passed() {
+		t.Errorf("Expected ErrNoFixtures for pattern lonely, got %v", lonely.Err)
+	}
+}
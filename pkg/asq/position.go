@@ -0,0 +1,130 @@
+package asq
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// ColumnEncoding selects the unit in which columns are counted. Match and
+// Capture columns are always bytes; other encodings are computed from a
+// LineIndex or from the text of the line before a position. The zero
+// ColumnEncoding leaves the choice to the consumer of the positions, and
+// counts bytes in this package.
+type ColumnEncoding int
+
+const (
+	// ColumnBytes counts bytes, as tree-sitter and Vim do.
+	ColumnBytes ColumnEncoding = iota + 1
+	// ColumnUTF8 counts the characters (Unicode code points) of UTF-8 text,
+	// as people reading the line do.
+	ColumnUTF8
+	// ColumnUTF16 counts UTF-16 code units, as the Language Server Protocol
+	// and SARIF do.
+	ColumnUTF16
+)
+
+var columnEncodingNames = []string{"", "byte", "utf-8", "utf-16"}
+
+func (e ColumnEncoding) String() string {
+	if e < 0 || int(e) >= len(columnEncodingNames) {
+		return fmt.Sprintf("ColumnEncoding(%d)", int(e))
+	}
+	return columnEncodingNames[e]
+}
+
+// ParseColumnEncoding returns the ColumnEncoding named s: "byte", "utf-8" or
+// "utf-16". The empty string returns the zero ColumnEncoding.
+func ParseColumnEncoding(s string) (ColumnEncoding, error) {
+	for i, name := range columnEncodingNames {
+		if s == name {
+			return ColumnEncoding(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown column encoding %q (expected %s)", s, strings.Join(columnEncodingNames[1:], ", "))
+}
+
+// Count returns the number of columns taken by prefix, the text of a line
+// before a position. Invalid UTF-8 counts one column per byte.
+func (e ColumnEncoding) Count(prefix []byte) int {
+	switch e {
+	case ColumnUTF8:
+		return utf8.RuneCount(prefix)
+	case ColumnUTF16:
+		units := 0
+		for len(prefix) > 0 {
+			r, size := utf8.DecodeRune(prefix)
+			prefix = prefix[size:]
+			if utf16.RuneLen(r) == 2 {
+				units += 2
+			} else {
+				units++
+			}
+		}
+		return units
+	}
+	return len(prefix)
+}
+
+// LineIndex maps the byte offsets of a file's contents to lines and columns.
+type LineIndex struct {
+	contents []byte
+	starts   []int // byte offset of the start of each line
+}
+
+// NewLineIndex indexes the lines of contents.
+func NewLineIndex(contents []byte) *LineIndex {
+	starts := []int{0}
+	for i := 0; ; {
+		n := bytes.IndexByte(contents[i:], '\n')
+		if n < 0 {
+			break
+		}
+		i += n + 1
+		starts = append(starts, i)
+	}
+	return &LineIndex{contents: contents, starts: starts}
+}
+
+// Lines returns the number of lines.
+func (ix *LineIndex) Lines() int {
+	return len(ix.starts)
+}
+
+// LineStart returns the byte offset of the start of line, which is 1-based.
+func (ix *LineIndex) LineStart(line int) int {
+	return ix.starts[line-1]
+}
+
+// Line returns the 1-based line holding the byte offset.
+func (ix *LineIndex) Line(offset int) int {
+	return sort.Search(len(ix.starts), func(i int) bool { return ix.starts[i] > offset })
+}
+
+// Position returns the 1-based line and the 0-based column, counted in enc,
+// of the byte offset. Offsets beyond the contents count as their end.
+func (ix *LineIndex) Position(offset int, enc ColumnEncoding) (line, col int) {
+	offset = min(max(offset, 0), len(ix.contents))
+	line = ix.Line(offset)
+	return line, enc.Count(ix.contents[ix.starts[line-1]:offset])
+}
+
+// Column returns the 0-based column, counted in enc, of the byte offset.
+func (ix *LineIndex) Column(offset int, enc ColumnEncoding) int {
+	_, col := ix.Position(offset, enc)
+	return col
+}
+
+// pointAt converts a byte offset into a tree-sitter row/column point.
+func pointAt(contents []byte, offset int) sitter.Point {
+	lineStart := bytes.LastIndexByte(contents[:offset], '\n') + 1
+	return sitter.Point{
+		Row:    uint32(bytes.Count(contents[:lineStart], []byte("\n"))),
+		Column: uint32(offset - lineStart),
+	}
+}
@@ -0,0 +1,54 @@
+package asq_test
+
+import (
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+func TestLineIndex(t *testing.T) {
+	contents := []byte("package a\n\nvar s = \"é😀\" + t\n")
+	lines := asq.NewLineIndex(contents)
+	if lines.Lines() != 4 {
+		t.Errorf("Expected 4 lines, got %d", lines.Lines())
+	}
+
+	offset := len("package a\n\nvar s = \"é😀\" + ")
+	tests := []struct {
+		enc      asq.ColumnEncoding
+		expected int
+	}{
+		{asq.ColumnBytes, 19},
+		{asq.ColumnUTF8, 15},
+		{asq.ColumnUTF16, 16},
+		{0, 19},
+	}
+	for _, tt := range tests {
+		line, col := lines.Position(offset, tt.enc)
+		if line != 3 || col != tt.expected {
+			t.Errorf("Expected %v position 3:%d, got %d:%d", tt.enc, tt.expected, line, col)
+		}
+	}
+
+	if line, col := lines.Position(len(contents), asq.ColumnUTF8); line != 4 || col != 0 {
+		t.Errorf("Expected the end of the file at 4:0, got %d:%d", line, col)
+	}
+	if start := lines.LineStart(3); start != 11 {
+		t.Errorf("Expected line 3 to start at byte 11, got %d", start)
+	}
+}
+
+func TestParseColumnEncoding(t *testing.T) {
+	for _, enc := range []asq.ColumnEncoding{asq.ColumnBytes, asq.ColumnUTF8, asq.ColumnUTF16} {
+		got, err := asq.ParseColumnEncoding(enc.String())
+		if err != nil || got != enc {
+			t.Errorf("Expected %v, got %v (%v)", enc, got, err)
+		}
+	}
+	if got, err := asq.ParseColumnEncoding(""); err != nil || got != 0 {
+		t.Errorf("Expected the zero encoding for an empty string, got %v (%v)", got, err)
+	}
+	if _, err := asq.ParseColumnEncoding("utf-32"); err == nil {
+		t.Errorf("Expected an error for an unknown encoding")
+	}
+}
@@ -12,12 +12,19 @@ type RangeInterval struct {
 	Start    token.Pos // Start offset in the line
 	TokenEnd token.Pos // End offset in the line
 	End      token.Pos
+	// Ident is the identifier the interval made a wildcard, or nil if the
+	// interval was dropped or has not been reached yet.
+	Ident *ast.Ident
 }
 
 // QueryContext is an internal struct used during the first pass of AST processing
 // to track which identifiers should be treated as wildcards based on active intervals.
 type QueryContext struct {
 	wildcardRanges []RangeInterval // Active intervals for wildcard tags
+	doneRanges     []RangeInterval // Intervals already applied or dropped
+	// bindArguments makes calls with a metavariable among their arguments
+	// match the arguments one for one, to bind it for a replacement.
+	bindArguments bool
 }
 
 // NewQueryContext creates a new QueryContext instance
@@ -59,7 +66,7 @@ func NewQueryContext(file *ast.File) (*QueryContext, token.Pos, token.Pos) {
 func (p *QueryContext) SetLastIntervalEnd(start token.Pos) {
 	if len(p.wildcardRanges) > 0 {
 		lastInterval := p.wildcardRanges[len(p.wildcardRanges)-1]
-		Debug("setting last wildcard range %d-%d to %d", lastInterval.Start, lastInterval.End, start-1)
+		Debug("setting last wildcard range %d-%d to %d", lastInterval.Start, lastInterval.End, start)
 		lastInterval.End = start
 		p.wildcardRanges[len(p.wildcardRanges)-1] = lastInterval
 	}
@@ -70,7 +77,7 @@ func (p *QueryContext) AddInterval(c *ast.Comment) {
 	start, end := c.Pos(), c.End()
 	Debug("=== start add %d-%d", start, end)
 	if len(p.wildcardRanges) > 0 {
-		p.SetLastIntervalEnd(start - 1)
+		p.SetLastIntervalEnd(start)
 	}
 	newInterval := RangeInterval{
 		comment:  c,
@@ -82,6 +89,12 @@ func (p *QueryContext) AddInterval(c *ast.Comment) {
 	p.wildcardRanges = append(p.wildcardRanges, newInterval)
 }
 
+// Intervals returns every wildcard interval in order, with the identifier each
+// was applied to by the IsWildcard calls so far.
+func (p *QueryContext) Intervals() []RangeInterval {
+	return append(append([]RangeInterval(nil), p.doneRanges...), p.wildcardRanges...)
+}
+
 // IsWildcard checks if the given node should be treated as a wildcard.
 // A node is considered a wildcard if either:
 // 1. It is an ast.Ident node with a name prefixed by "_asq_"
@@ -103,14 +116,17 @@ func (p *QueryContext) IsWildcard(node ast.Node) bool {
 		nodePos, nodeEnd := node.Pos(), node.End()
 		if firstRange.Start < nodePos && firstRange.End >= nodeEnd {
 			p.wildcardRanges = p.wildcardRanges[1:]
-			_, isIdent := node.(*ast.Ident)
+			ident, isIdent := node.(*ast.Ident)
+			firstRange.Ident = ident
+			p.doneRanges = append(p.doneRanges, firstRange)
 			return isIdent
-		} else if firstRange.End < nodePos {
-			p.wildcardRanges = p.wildcardRanges[1:]
-			continue
 		} else if firstRange.End > nodeEnd {
 			break
 		}
+		// The interval ends before or inside the node, so neither the node
+		// nor any later one can be the first entity in it
+		p.wildcardRanges = p.wildcardRanges[1:]
+		p.doneRanges = append(p.doneRanges, firstRange)
 	}
 	return false
 }
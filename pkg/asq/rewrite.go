@@ -0,0 +1,461 @@
+package asq
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// MetavariablePrefix starts the names of metavariables: identifiers of a
+// pattern that match any identifier and are captured under their own name,
+// so that a replacement can refer to what they matched.
+const MetavariablePrefix = "_asq_"
+
+// IsMetavariable reports whether name is the name of a metavariable.
+func IsMetavariable(name string) bool {
+	return strings.HasPrefix(name, MetavariablePrefix) && len(name) > len(MetavariablePrefix)
+}
+
+var (
+	// ErrNoReplacement is returned for query files without a replacement.
+	ErrNoReplacement = errors.New("could not find //asq_replace_start and //asq_replace_end comments")
+	// ErrInconsistentBinding is reported for matches in which a metavariable
+	// that occurs more than once in the pattern matched different text.
+	ErrInconsistentBinding = errors.New("metavariable bound inconsistently")
+	// ErrOverlap is reported for matches that overlap a match being rewritten.
+	ErrOverlap = errors.New("overlaps another match")
+	// ErrRejected is reported for matches whose edit was turned down, such as
+	// during an interactive review.
+	ErrRejected = errors.New("edit rejected")
+	// ErrStale is reported for matches whose text is no longer in the file.
+	ErrStale = errors.New("file changed since it was searched")
+)
+
+// Replacement is the code that replaces each match of a pattern. It is read
+// from the lines between the //asq_replace_start and //asq_replace_end
+// comments of a query file, usually next to the pattern:
+//
+//	//asq_start
+//	_asq_X.Inst().Foo()
+//	//asq_end
+//	//asq_replace_start
+//	_asq_X.Foo()
+//	//asq_replace_end
+//
+// Metavariables in the replacement stand for the text that the metavariable
+// of the same name matched in the pattern.
+//
+// Rewritten files gain the imports of the packages that the replacement
+// refers to, such as os for os.ReadFile, and lose the imports that the
+// rewrite leaves unused. The import paths of packages that are not in the
+// standard library, or whose name several standard packages share, are given
+// by comments of the form
+//
+//	//asq:import yaml gopkg.in/yaml.v3
+type Replacement struct {
+	// Text is the replacement code, without the indentation common to its
+	// lines.
+	Text string
+	// Imports maps the package names used in Text to import paths, for files
+	// that do not import them yet. Names missing from it are looked up among
+	// the standard library packages.
+	Imports map[string]string
+	parts   []replacementPart
+}
+
+// replacementPart is a run of literal code or a metavariable.
+type replacementPart struct {
+	text         string
+	metavariable bool
+}
+
+// LoadReplacement reads the replacement of a query file.
+func LoadReplacement(filePath string) (*Replacement, error) {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var lines []string
+	imports := make(map[string]string)
+	collecting, found := false, false
+	s := bufio.NewScanner(bytes.NewReader(contents))
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); strings.HasPrefix(line, "//asq:import ") {
+			name, path := ParseImport(strings.TrimPrefix(line, "//asq:import "))
+			imports[name] = path
+			continue
+		}
+		switch commentDirective(s.Text()) {
+		case "asq_replace_start":
+			collecting, lines = true, nil
+			continue
+		case "asq_replace_end":
+			found = collecting
+			collecting = false
+		}
+		if found {
+			break
+		}
+		if collecting {
+			lines = append(lines, s.Text())
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNoReplacement
+	}
+	r := NewReplacement(dedent(lines))
+	r.Imports = imports
+	return r, nil
+}
+
+// NewReplacement returns the Replacement with code text.
+func NewReplacement(text string) *Replacement {
+	r := &Replacement{Text: text}
+	src := []byte(text)
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+	last := 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok != token.IDENT || !IsMetavariable(lit) {
+			continue
+		}
+		offset := file.Offset(pos)
+		r.parts = append(r.parts, replacementPart{text: text[last:offset]}, replacementPart{text: lit, metavariable: true})
+		last = offset + len(lit)
+	}
+	r.parts = append(r.parts, replacementPart{text: text[last:]})
+	return r
+}
+
+// commentDirective returns the text of a line holding only a comment, without
+// the comment markers and surrounding space.
+func commentDirective(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "//") && !strings.HasPrefix(trimmed, "/*") {
+		return ""
+	}
+	trimmed = strings.TrimPrefix(trimmed, "//")
+	trimmed = strings.TrimPrefix(trimmed, "/*")
+	trimmed = strings.TrimSuffix(trimmed, "*/")
+	return strings.TrimSpace(trimmed)
+}
+
+// dedent joins lines without the blank lines at either end and without the
+// indentation common to the other lines.
+func dedent(lines []string) string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	indent, first := "", true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lead := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			indent, first = lead, false
+		}
+		for !strings.HasPrefix(lead, indent) {
+			indent = indent[:len(indent)-1]
+		}
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = strings.TrimPrefix(line, indent)
+	}
+	return strings.Join(out, "\n")
+}
+
+// Metavariables returns the names of the metavariables of the replacement,
+// each once, in order of first use.
+func (r *Replacement) Metavariables() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, p := range r.parts {
+		if p.metavariable && !seen[p.text] {
+			seen[p.text] = true
+			names = append(names, p.text)
+		}
+	}
+	return names
+}
+
+// CheckQuery returns an error if the replacement uses a metavariable that
+// query does not capture, so that no match could bind it.
+func (r *Replacement) CheckQuery(query string) error {
+	for _, name := range r.Metavariables() {
+		if !regexp.MustCompile(`@` + regexp.QuoteMeta(name) + `\b`).MatchString(query) {
+			return fmt.Errorf("replacement uses %s, which the pattern does not bind", name)
+		}
+	}
+	return nil
+}
+
+// Expand returns the replacement for m, with each metavariable replaced by
+// the text of the capture of the same name. It fails with
+// ErrInconsistentBinding if a metavariable captured different texts.
+func (r *Replacement) Expand(m Match) (string, error) {
+	bound := make(map[string]string)
+	for _, c := range m.Captures {
+		if !IsMetavariable(c.Name) {
+			continue
+		}
+		if text, ok := bound[c.Name]; ok && text != c.Text {
+			return "", fmt.Errorf("%w: %s matched both %q and %q", ErrInconsistentBinding, c.Name, text, c.Text)
+		}
+		bound[c.Name] = c.Text
+	}
+
+	var sb strings.Builder
+	for _, p := range r.parts {
+		if !p.metavariable {
+			sb.WriteString(p.text)
+			continue
+		}
+		text, ok := bound[p.text]
+		if !ok {
+			return "", fmt.Errorf("%s is not bound by the match", p.text)
+		}
+		sb.WriteString(text)
+	}
+	return sb.String(), nil
+}
+
+// Edit replaces the code of a match, the bytes from Start to End of its
+// file, with Text.
+type Edit struct {
+	Match      Match
+	Start, End int
+	Text       string
+}
+
+// SkippedMatch is a match that a rewrite left unchanged, and why.
+type SkippedMatch struct {
+	Match  Match
+	Reason error
+}
+
+// FileRewrite is the outcome of rewriting one file.
+type FileRewrite struct {
+	Path      string
+	Original  []byte
+	Rewritten []byte
+	Edits     []Edit // the edits applied, in file order
+	Skipped   []SkippedMatch
+
+	formatted bool        // Original is formatted like gofmt does
+	imports   *importPlan // nil if the replacement refers to no package
+}
+
+// Changed reports whether the rewrite changes the file.
+func (fr *FileRewrite) Changed() bool {
+	return !bytes.Equal(fr.Original, fr.Rewritten)
+}
+
+// RewriteFile replaces every match of the file at path with r, expanded for
+// the match. Matches that overlap are resolved deterministically: the one
+// that starts first is rewritten, or the longest of those starting at the
+// same byte, and the others are skipped with ErrOverlap. Matches with
+// inconsistent metavariables are skipped too, as are matches whose text is no
+// longer in the file.
+//
+// If the file was formatted like gofmt does, the result is formatted with
+// go/format. Otherwise only the replacement code is formatted, and every byte
+// outside the matches, comments included, is left as it was. RewriteFile
+// fails if the result is not valid Go while the original was. It does not
+// write the file; see FileRewrite.Write.
+func RewriteFile(path string, matches []Match, r *Replacement) (*FileRewrite, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	fr := &FileRewrite{Path: path, Original: contents}
+	r, fr.imports = planImports(contents, r)
+
+	var edits []Edit
+	for _, m := range matches {
+		if m.StartByte < 0 || m.EndByte > len(contents) || m.StartByte > m.EndByte || string(contents[m.StartByte:m.EndByte]) != m.Text {
+			fr.Skipped = append(fr.Skipped, SkippedMatch{Match: m, Reason: ErrStale})
+			continue
+		}
+		text, err := r.Expand(m)
+		if err != nil {
+			fr.Skipped = append(fr.Skipped, SkippedMatch{Match: m, Reason: err})
+			continue
+		}
+		edits = append(edits, Edit{Match: m, Start: m.StartByte, End: m.EndByte, Text: text})
+	}
+
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].Start != edits[j].Start {
+			return edits[i].Start < edits[j].Start
+		}
+		return edits[i].End > edits[j].End
+	})
+	end := -1
+	for _, e := range edits {
+		if n := len(fr.Edits); n > 0 && e.Start == fr.Edits[n-1].Start && e.End == fr.Edits[n-1].End && e.Text == fr.Edits[n-1].Text {
+			continue // the same match found twice
+		}
+		if e.Start < end {
+			fr.Skipped = append(fr.Skipped, SkippedMatch{Match: e.Match, Reason: ErrOverlap})
+			continue
+		}
+		fr.Edits = append(fr.Edits, e)
+		end = e.End
+	}
+
+	fr.formatted = isFormatted(contents)
+	if fr.Rewritten, err = fr.With(fr.Edits); err != nil {
+		return nil, err
+	}
+	return fr, nil
+}
+
+// With returns the contents of the file with only edits applied, formatted as
+// RewriteFile formats them. The edits must be in file order and must not
+// overlap, like those of fr.Edits.
+func (fr *FileRewrite) With(edits []Edit) ([]byte, error) {
+	if len(edits) == 0 {
+		return fr.Original, nil
+	}
+	contents := fr.Original
+	var out bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		out.Write(contents[last:e.Start])
+		text := e.Text
+		if !fr.formatted {
+			text = formatFragment(text)
+		}
+		out.WriteString(indentFollowing(text, lineIndent(contents, e.Start)))
+		last = e.End
+	}
+	out.Write(contents[last:])
+	rewritten := out.Bytes()
+	if fr.imports != nil {
+		rewritten = fr.imports.fix(rewritten)
+	}
+
+	if fr.formatted {
+		result, err := format.Source(rewritten)
+		if err != nil {
+			return nil, fmt.Errorf("rewriting %s gives invalid Go: %w", fr.Path, err)
+		}
+		return result, nil
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), fr.Path, contents, parser.SkipObjectResolution); err == nil {
+		if _, err := parser.ParseFile(token.NewFileSet(), fr.Path, rewritten, parser.SkipObjectResolution); err != nil {
+			return nil, fmt.Errorf("rewriting %s gives invalid Go: %w", fr.Path, err)
+		}
+	}
+	return rewritten, nil
+}
+
+// Only returns the rewrite of the file with only edits applied. The edits are
+// those of fr.Edits, in order, possibly with their Text changed; the others
+// are skipped with ErrRejected.
+func (fr *FileRewrite) Only(edits []Edit) (*FileRewrite, error) {
+	return fr.keep(edits, nil)
+}
+
+// keep is Only, with the reasons for leaving out edits, by their byte range,
+// that were not simply rejected.
+func (fr *FileRewrite) keep(edits []Edit, reasons map[[2]int]error) (*FileRewrite, error) {
+	rewritten, err := fr.With(edits)
+	if err != nil {
+		return nil, err
+	}
+	only := &FileRewrite{
+		Path:      fr.Path,
+		Original:  fr.Original,
+		Rewritten: rewritten,
+		Edits:     edits,
+		Skipped:   slices.Clip(fr.Skipped),
+		formatted: fr.formatted,
+		imports:   fr.imports,
+	}
+	kept := make(map[[2]int]bool, len(edits))
+	for _, e := range edits {
+		kept[[2]int{e.Start, e.End}] = true
+	}
+	for _, e := range fr.Edits {
+		key := [2]int{e.Start, e.End}
+		if kept[key] {
+			continue
+		}
+		reason := reasons[key]
+		if reason == nil {
+			reason = ErrRejected
+		}
+		only.Skipped = append(only.Skipped, SkippedMatch{Match: e.Match, Reason: reason})
+	}
+	return only, nil
+}
+
+// Write replaces the file with the rewritten contents, keeping its
+// permissions, if the rewrite changes it.
+func (fr *FileRewrite) Write() error {
+	if !fr.Changed() {
+		return nil
+	}
+	info, err := os.Stat(fr.Path)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fr.Path, fr.Rewritten, info.Mode().Perm())
+}
+
+// isFormatted reports whether contents is valid Go formatted like gofmt does.
+func isFormatted(contents []byte) bool {
+	formatted, err := format.Source(contents)
+	return err == nil && bytes.Equal(formatted, contents)
+}
+
+// formatFragment formats code that is not a whole file, such as an
+// expression or statements, or returns it unchanged if it does not parse.
+func formatFragment(code string) string {
+	formatted, err := format.Source([]byte(code))
+	if err != nil {
+		return code
+	}
+	return string(formatted)
+}
+
+// lineIndent returns the indentation of the line holding the byte offset.
+func lineIndent(contents []byte, offset int) string {
+	start := bytes.LastIndexByte(contents[:offset], '\n') + 1
+	line := contents[start:]
+	return string(line[:len(line)-len(bytes.TrimLeft(line, " \t"))])
+}
+
+// indentFollowing prefixes the lines of text after the first with indent.
+func indentFollowing(text, indent string) string {
+	if indent == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, "\n", "\n"+indent)
+}
@@ -0,0 +1,436 @@
+package asq_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+func TestLoadReplacement(t *testing.T) {
+	tests := []struct {
+		name          string
+		contents      string
+		expected      string
+		metavariables []string
+		err           error
+	}{
+		{
+			name: "metavariables",
+			contents: `package q
+
+func q() {
+	//asq_start
+	_asq_X.Inst().Foo(_asq_Y)
+	//asq_end
+	// asq_replace_start
+	_asq_X.Foo(_asq_Y, "_asq_Z", _asq_X)
+	// asq_replace_end
+}
+`,
+			expected:      `_asq_X.Foo(_asq_Y, "_asq_Z", _asq_X)`,
+			metavariables: []string{"_asq_X", "_asq_Y"},
+		},
+		{
+			name: "dedented",
+			contents: `package q
+
+func q() {
+	//asq_replace_start
+
+	if _asq_X != nil {
+		return _asq_X
+	}
+
+	//asq_replace_end
+}
+`,
+			expected:      "if _asq_X != nil {\n\treturn _asq_X\n}",
+			metavariables: []string{"_asq_X"},
+		},
+		{
+			name:     "missing",
+			contents: "package q\n\n//asq_start\nvar x = 1\n//asq_end\n",
+			err:      asq.ErrNoReplacement,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("", "asq-rewrite-*")
+			if err != nil {
+				t.Fatalf("Failed to create temp directory: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			file := filepath.Join(tmpDir, "q.go")
+			if err := os.WriteFile(file, []byte(tt.contents), 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+			r, err := asq.LoadReplacement(file)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if r.Text != tt.expected {
+				t.Errorf("Expected replacement %q, got %q", tt.expected, r.Text)
+			}
+			if got := r.Metavariables(); !reflect.DeepEqual(got, tt.metavariables) {
+				t.Errorf("Expected metavariables %v, got %v", tt.metavariables, got)
+			}
+		})
+	}
+}
+
+func TestReplacementCheckQuery(t *testing.T) {
+	r := asq.NewReplacement("_asq_X.Foo(_asq_Y)")
+	if err := r.CheckQuery(`(call_expression function: (identifier) @_asq_X arguments: (argument_list (identifier) @_asq_Y)) @x`); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := r.CheckQuery(`(call_expression function: (identifier) @_asq_X) @x`); err == nil {
+		t.Errorf("Expected an error for the unbound _asq_Y")
+	}
+}
+
+func TestCallArgumentMatching(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-rewrite-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	source := "package a\n\nfunc f() {\n\tfoo()\n\tfoo(1)\n\tfoo(1, 2)\n}\n"
+	file := filepath.Join(tmpDir, "a.go")
+	if err := os.WriteFile(file, []byte(source), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	// Plain queries match calls of any arity; rewrite queries match the
+	// arguments one for one, binding metavariables and comparing the others
+	tests := []struct {
+		name     string
+		pattern  string
+		rewrite  bool
+		expected []int
+	}{
+		{name: "metavariable", pattern: "foo(_asq_X)", expected: []int{4, 5, 6}},
+		{name: "wildcard", pattern: "foo(/***/y)", expected: []int{4, 5, 6}},
+		{name: "identifier", pattern: "foo(y)", expected: []int{4, 5, 6}},
+		{name: "rewrite metavariable", pattern: "foo(_asq_X)", rewrite: true, expected: []int{5}},
+		{name: "rewrite two metavariables", pattern: "foo(_asq_X, _asq_Y)", rewrite: true, expected: []int{6}},
+		{name: "rewrite without arguments", pattern: "foo()", rewrite: true, expected: []int{4}},
+		{name: "rewrite literal argument", pattern: "foo(1, _asq_X)", rewrite: true, expected: []int{6}},
+		{name: "rewrite other literal", pattern: "foo(_asq_X, 3)", rewrite: true},
+		{name: "rewrite identifier argument", pattern: "foo(y)", rewrite: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern := filepath.Join(tmpDir, "_asq_pattern.go")
+			code := "package a\n\nfunc asq_pattern() {\n\t//asq_start\n\t" + tt.pattern + "\n\t//asq_end\n}\n"
+			if err := os.WriteFile(pattern, []byte(code), 0644); err != nil {
+				t.Fatalf("Failed to write pattern file: %v", err)
+			}
+			query, err := asq.ExtractTreeSitterQuery(pattern)
+			if tt.rewrite {
+				query, err = asq.ExtractRewriteQuery(context.Background(), pattern)
+			}
+			if err != nil {
+				t.Fatalf("Failed to extract query: %v", err)
+			}
+			var rows []int
+			for m, err := range asq.MatchFile(context.Background(), file, query) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				rows = append(rows, m.Row)
+			}
+			if !reflect.DeepEqual(rows, tt.expected) {
+				t.Errorf("Expected matches on lines %v, got %v", tt.expected, rows)
+			}
+		})
+	}
+}
+
+func TestRewriteFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      string
+		query       string
+		replacement string
+		expected    string
+		skipped     []error
+	}{
+		{
+			name:        "keeps comments",
+			source:      "package a\n\nfunc f() {\n\t// before\n\tg(x) // after\n\t/* inside */ g(y)\n}\n",
+			query:       `(call_expression function: (identifier) @fn (#eq? @fn "g") arguments: (argument_list (identifier) @_asq_A)) @x`,
+			replacement: "h(_asq_A, 1)",
+			expected:    "package a\n\nfunc f() {\n\t// before\n\th(x, 1) // after\n\t/* inside */ h(y, 1)\n}\n",
+		},
+		{
+			name:        "outer match wins",
+			source:      "package a\n\nvar v = f(g(1))\n",
+			query:       `(call_expression function: (identifier) @_asq_F arguments: (argument_list (_) @_asq_A)) @x`,
+			replacement: "call(_asq_F, _asq_A)",
+			expected:    "package a\n\nvar v = call(f, g(1))\n",
+			skipped:     []error{asq.ErrOverlap},
+		},
+		{
+			name:        "inconsistent metavariable",
+			source:      "package a\n\nvar v, w = eq(a, a), eq(a, b)\n",
+			query:       `(call_expression arguments: (argument_list (identifier) @_asq_A (identifier) @_asq_A)) @x`,
+			replacement: "true",
+			expected:    "package a\n\nvar v, w = true, eq(a, b)\n",
+			skipped:     []error{asq.ErrInconsistentBinding},
+		},
+		{
+			name:        "formats statements",
+			source:      "package a\n\nfunc f() {\n\tif ok {\n\t\tg(x)\n\t}\n}\n",
+			query:       `(expression_statement (call_expression arguments: (argument_list (identifier) @_asq_A))) @x`,
+			replacement: "if _asq_A!=nil {\n\tg(_asq_A)\n}",
+			expected:    "package a\n\nfunc f() {\n\tif ok {\n\t\tif x != nil {\n\t\t\tg(x)\n\t\t}\n\t}\n}\n",
+		},
+		{
+			name:        "other names kept",
+			source:      "package a\n\nfunc f() {\n\tioutil.ReadFile(a)\n\tfmt.Println(b)\n\tioutil.WriteFile(c)\n}\n",
+			query:       `(call_expression function: (selector_expression operand: (identifier) @name (#eq? @name "ioutil") field: (field_identifier) @field (#eq? @field "ReadFile")) arguments: (argument_list . (_) @_asq_X .)) @x`,
+			replacement: "readFile(_asq_X)",
+			expected:    "package a\n\nfunc f() {\n\treadFile(a)\n\tfmt.Println(b)\n\tioutil.WriteFile(c)\n}\n",
+		},
+		{
+			name:        "other arguments kept",
+			source:      "package a\n\nfunc f() {\n\tioutil.ReadFile(a, \"b\")\n\tioutil.ReadFile(p, \"zzz\")\n\tioutil.ReadFile(p, b)\n}\n",
+			query:       `(call_expression function: (selector_expression operand: (identifier) @name (#eq? @name "ioutil") field: (field_identifier) @field (#eq? @field "ReadFile")) arguments: (argument_list . (_) @_asq_X . (_) @value (#eq? @value "\"b\"") .)) @x`,
+			replacement: "readFile(_asq_X)",
+			expected:    "package a\n\nfunc f() {\n\treadFile(a)\n\tioutil.ReadFile(p, \"zzz\")\n\tioutil.ReadFile(p, b)\n}\n",
+		},
+		{
+			name:        "other methods kept",
+			source:      "package a\n\nfunc f() {\n\te.Inst().Foo()\n\tx.Other().Bar()\n\tx.Inst().Bar()\n}\n",
+			query:       `(call_expression function: (selector_expression operand: (call_expression function: (selector_expression operand: (identifier) @_asq_E field: (field_identifier) @field (#eq? @field "Inst")) arguments: (argument_list)) field: (field_identifier) @field (#eq? @field "Foo")) arguments: (argument_list)) @x`,
+			replacement: "_asq_E.Foo()",
+			expected:    "package a\n\nfunc f() {\n\te.Foo()\n\tx.Other().Bar()\n\tx.Inst().Bar()\n}\n",
+		},
+		{
+			name:        "unformatted file",
+			source:      "package a\nfunc f() {\n  g(x)  // odd\n}\n",
+			query:       `(call_expression arguments: (argument_list (identifier) @_asq_A)) @x`,
+			replacement: "h( _asq_A )",
+			expected:    "package a\nfunc f() {\n  h(x)  // odd\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("", "asq-rewrite-*")
+			if err != nil {
+				t.Fatalf("Failed to create temp directory: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			file := filepath.Join(tmpDir, "a.go")
+			if err := os.WriteFile(file, []byte(tt.source), 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+			matches, err := asq.ValidateTreeSitterQuery(file, tt.query)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			fr, err := asq.RewriteFile(file, matches, asq.NewReplacement(tt.replacement))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := string(fr.Rewritten); got != tt.expected {
+				t.Errorf("Expected rewritten file:\n%s\ngot:\n%s", tt.expected, got)
+			}
+			var skipped []error
+			for _, s := range fr.Skipped {
+				skipped = append(skipped, s.Reason)
+			}
+			if len(skipped) != len(tt.skipped) {
+				t.Fatalf("Expected %d skipped matches, got %v", len(tt.skipped), skipped)
+			}
+			for i, err := range tt.skipped {
+				if !errors.Is(skipped[i], err) {
+					t.Errorf("Expected skip reason %v, got %v", err, skipped[i])
+				}
+			}
+
+			if err := fr.Write(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if contents, err := os.ReadFile(file); err != nil || string(contents) != tt.expected {
+				t.Errorf("Expected the file to hold the rewrite, got %q (%v)", contents, err)
+			}
+		})
+	}
+}
+
+func TestRewriteImports(t *testing.T) {
+	query := `(call_expression function: (selector_expression) arguments: (argument_list (_) @_asq_A)) @x`
+	tests := []struct {
+		name        string
+		source      string
+		replacement string
+		imports     map[string]string
+		expected    string
+	}{
+		{
+			name: "grouped",
+			source: `package a
+
+import (
+	"fmt"
+	"io/ioutil" // deprecated
+
+	"example.com/x"
+)
+
+var _, _ = fmt.Sprint, x.Y
+
+func f() {
+	b, _ := ioutil.ReadFile("a")
+}
+`,
+			replacement: "os.ReadFile(_asq_A)",
+			expected: `package a
+
+import (
+	"fmt"
+	"os"
+
+	"example.com/x"
+)
+
+var _, _ = fmt.Sprint, x.Y
+
+func f() {
+	b, _ := os.ReadFile("a")
+}
+`,
+		},
+		{
+			name: "alias",
+			source: `package a
+
+import (
+	"io/ioutil"
+	goos "os"
+)
+
+var _ = goos.Args
+
+func f() {
+	b, _ := ioutil.ReadFile("a")
+}
+`,
+			replacement: "os.ReadFile(_asq_A)",
+			expected: `package a
+
+import (
+	goos "os"
+)
+
+var _ = goos.Args
+
+func f() {
+	b, _ := goos.ReadFile("a")
+}
+`,
+		},
+		{
+			name: "single import",
+			source: `package a
+
+import "io/ioutil"
+
+func f() {
+	b, _ := ioutil.ReadFile("a")
+}
+`,
+			replacement: "os.ReadFile(_asq_A)",
+			expected: `package a
+
+import "os"
+
+func f() {
+	b, _ := os.ReadFile("a")
+}
+`,
+		},
+		{
+			name: "configured path",
+			source: `package a
+
+import "encoding/json"
+
+func f(v any) {
+	b, _ := json.Marshal(v)
+}
+`,
+			replacement: "yml.Marshal(_asq_A)",
+			imports:     map[string]string{"yml": "gopkg.in/yaml.v3"},
+			expected: `package a
+
+import yml "gopkg.in/yaml.v3"
+
+func f(v any) {
+	b, _ := yml.Marshal(v)
+}
+`,
+		},
+		{
+			name: "local variable",
+			source: `package a
+
+func f(json T) {
+	b, _ := json.Marshal(1)
+}
+`,
+			replacement: "json.Encode(_asq_A)",
+			expected: `package a
+
+func f(json T) {
+	b, _ := json.Encode(1)
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("", "asq-rewrite-*")
+			if err != nil {
+				t.Fatalf("Failed to create temp directory: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			file := filepath.Join(tmpDir, "a.go")
+			if err := os.WriteFile(file, []byte(tt.source), 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+			matches, err := asq.ValidateTreeSitterQuery(file, query)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			r := asq.NewReplacement(tt.replacement)
+			r.Imports = tt.imports
+			fr, err := asq.RewriteFile(file, matches, r)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := string(fr.Rewritten); got != tt.expected {
+				t.Errorf("Expected rewritten file:\n%s\ngot:\n%s", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package asq
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// scopeRole is the kind of snippet group a scope bounds, apart from a
+// statement group.
+type scopeRole int
+
+const (
+	roleNone    scopeRole = iota
+	roleFunc              // function or method declaration
+	roleDecl              // var, const, type or import declaration
+	roleSpec              // type in a type (...) block
+	roleFuncLit           // function literal of at least largeFuncLitLines lines
+)
+
+// statementTypes are the tree-sitter node types of Go statements.
+var statementTypes = map[string]bool{
+	"expression_statement": true, "send_statement": true, "receive_statement": true,
+	"inc_statement": true, "dec_statement": true, "assignment_statement": true,
+	"short_var_declaration": true, "labeled_statement": true, "fallthrough_statement": true,
+	"break_statement": true, "continue_statement": true, "goto_statement": true,
+	"return_statement": true, "go_statement": true, "defer_statement": true,
+	"if_statement": true, "for_statement": true, "expression_switch_statement": true,
+	"type_switch_statement": true, "select_statement": true, "block": true,
+	"empty_statement": true, "var_declaration": true, "const_declaration": true,
+	"type_declaration": true,
+}
+
+// statementParents are the node types whose statements are groups of their own.
+var statementParents = map[string]bool{
+	"block": true, "expression_case": true, "default_case": true, "type_case": true, "communication_case": true,
+}
+
+// declKinds maps declaration node types to their MatchGroup.Kind.
+var declKinds = map[string]string{
+	"var_declaration": "var", "const_declaration": "const", "type_declaration": "type", "import_declaration": "import",
+}
+
+// scope is a node of the syntax tree that can bound a snippet group.
+type scope struct {
+	start, end         int // byte offsets
+	startLine, endLine int
+	role               scopeRole
+	kind               string // MatchGroup.Kind for role
+	topLevel           bool   // declared at the root of the file
+	stmt               bool   // a statement of a block or case clause
+	parent             *scope // nearest enclosing scope
+}
+
+// bounds reports whether s bounds a group, other than a statement group, at
+// boundary.
+func (s *scope) bounds(boundary Boundary) bool {
+	switch s.role {
+	case roleFunc:
+		return true
+	case roleDecl:
+		return boundary != BoundaryFunction || s.topLevel
+	case roleSpec, roleFuncLit:
+		return boundary != BoundaryFunction
+	}
+	return false
+}
+
+// scopeIndex holds the scopes enclosing the matches of one file, recorded
+// from the tree-sitter tree while it is searched with SearchOptions.Scopes,
+// so that GroupMatches needs neither to parse the file again nor to walk its
+// whole tree. Scopes are kept sorted by start offset, enclosing scopes first,
+// and are looked up by binary search.
+type scopeIndex struct {
+	file  string
+	lines *LineIndex
+
+	mu      sync.Mutex
+	decls   [][2]int // line ranges of the top-level declarations, in order; nil until init
+	scopes  []*scope
+	byRange map[[2]int]*scope
+	sorted  bool
+}
+
+func newScopeIndex(file string, lines *LineIndex) *scopeIndex {
+	return &scopeIndex{file: file, lines: lines, byRange: make(map[[2]int]*scope)}
+}
+
+// init records the top-level declarations of the file the first time a node
+// of root's tree is added.
+func (idx *scopeIndex) init(root *sitter.Node) {
+	if idx.decls != nil {
+		return
+	}
+	idx.decls = [][2]int{}
+
+	cursor := sitter.NewTreeCursor(root)
+	defer cursor.Close()
+	for ok := cursor.GoToFirstChild(); ok; ok = cursor.GoToNextSibling() {
+		n := cursor.CurrentNode()
+		if !n.IsNamed() || n.Type() == "comment" || n.Type() == "package_clause" {
+			continue
+		}
+		idx.decls = append(idx.decls, [2]int{idx.lines.Line(int(n.StartByte())), idx.lines.Line(int(n.EndByte()))})
+	}
+}
+
+// add records the scopes enclosing the code between the byte offsets start
+// and end, descending to it from root, the root of a tree of the indexed
+// contents. Scopes already recorded for other code are shared.
+func (idx *scopeIndex) add(root *sitter.Node, start, end int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.init(root)
+
+	cursor := sitter.NewTreeCursor(root)
+	defer cursor.Close()
+	parent, outer := root, (*scope)(nil)
+	// The first child extending beyond start is the only one that can contain
+	// the code
+	for cursor.GoToFirstChildForByte(uint32(start)) >= 0 {
+		n := cursor.CurrentNode()
+		if int(n.StartByte()) > start || int(n.EndByte()) < end {
+			return
+		}
+		if s := idx.newScope(n, parent); s != nil {
+			key := [2]int{s.start, s.end}
+			if existing := idx.byRange[key]; existing != nil {
+				s = existing
+			} else {
+				s.parent = outer
+				idx.byRange[key] = s
+				idx.scopes = append(idx.scopes, s)
+				idx.sorted = false
+			}
+			outer = s
+		}
+		parent = n
+	}
+}
+
+// newScope returns the scope of n, whose parent node is parent, or nil if n
+// cannot bound a group.
+func (idx *scopeIndex) newScope(n, parent *sitter.Node) *scope {
+	s := &scope{start: int(n.StartByte()), end: int(n.EndByte())}
+	s.startLine, s.endLine = idx.lines.Line(s.start), idx.lines.Line(s.end)
+
+	typ, parentType := n.Type(), parent.Type()
+	switch typ {
+	case "function_declaration":
+		s.role, s.kind = roleFunc, "func"
+	case "method_declaration":
+		s.role, s.kind = roleFunc, "method"
+	case "var_declaration", "const_declaration", "type_declaration", "import_declaration":
+		s.role, s.kind = roleDecl, declKinds[typ]
+		s.topLevel = parentType == "source_file"
+	case "type_spec", "type_alias":
+		if parentType == "type_declaration" && parent.ChildCount() > 1 && parent.Child(1).Type() == "(" {
+			s.role, s.kind = roleSpec, "type"
+		}
+	case "func_literal":
+		if s.endLine-s.startLine+1 >= largeFuncLitLines {
+			s.role, s.kind = roleFuncLit, "func literal"
+		}
+	}
+	s.stmt = statementTypes[typ] && statementParents[parentType]
+
+	if s.role == roleNone && !s.stmt {
+		return nil
+	}
+	return s
+}
+
+// container returns the scope whose lines make up the group of the code
+// between the byte offsets start and end, with a description of it, or nil if
+// the code lies outside every declaration.
+func (idx *scopeIndex) container(start, end int, boundary Boundary) (*scope, string) {
+	if start < 0 {
+		return nil, ""
+	}
+	if !idx.sorted {
+		sort.Slice(idx.scopes, func(i, j int) bool {
+			a, b := idx.scopes[i], idx.scopes[j]
+			if a.start != b.start {
+				return a.start < b.start
+			}
+			return a.end > b.end // enclosing scopes first
+		})
+		idx.sorted = true
+	}
+
+	// The innermost scope containing the code encloses, or is, the last one
+	// starting before it
+	i := sort.Search(len(idx.scopes), func(i int) bool { return idx.scopes[i].start > start }) - 1
+	if i < 0 {
+		return nil, ""
+	}
+	inner := idx.scopes[i]
+	for inner != nil && (inner.end < end || inner.end == start) {
+		inner = inner.parent
+	}
+
+	if boundary == BoundaryStatement {
+		for s := inner; s != nil; s = s.parent {
+			if s.stmt {
+				return s, "statement"
+			}
+		}
+	}
+	for s := inner; s != nil; s = s.parent {
+		if s.bounds(boundary) {
+			return s, s.kind
+		}
+	}
+	return nil, ""
+}
+
+// matchRange returns the byte offsets of a match, using the start of its line
+// when they are not valid for the indexed contents, or -1 if neither is.
+func (idx *scopeIndex) matchRange(match Match) (int, int) {
+	if 0 <= match.StartByte && match.StartByte <= match.EndByte && match.EndByte <= len(idx.lines.contents) && match.EndByte > 0 {
+		return match.StartByte, match.EndByte
+	}
+	if match.Row < 1 || match.Row > idx.lines.Lines() {
+		return -1, -1
+	}
+	offset := idx.lines.LineStart(match.Row)
+	return offset, offset
+}
+
+// contextWindow returns the lines around row shown for a match outside every
+// declaration, kept clear of the declarations before and after it and of
+// blank lines at either end.
+func (idx *scopeIndex) contextWindow(lines [][]byte, row int) (int, int) {
+	first, last := max(1, row-contextLines), min(len(lines), row+contextLines)
+	next := sort.Search(len(idx.decls), func(i int) bool { return idx.decls[i][0] > row })
+	if next > 0 && idx.decls[next-1][1] < row {
+		first = max(first, idx.decls[next-1][1]+1)
+	}
+	if next < len(idx.decls) {
+		last = min(last, idx.decls[next][0]-1)
+	}
+	for first < row && len(bytes.TrimSpace(lines[first-1])) == 0 {
+		first++
+	}
+	for last > row && len(bytes.TrimSpace(lines[last-1])) == 0 {
+		last--
+	}
+	return first, last
+}
+
+// matchScopes returns the scope index shared by matches, which a search with
+// SearchOptions.Scopes records on each of them. Matches without it, such as
+// those of other searches or built by hand, are indexed by parsing filePath.
+func matchScopes(filePath string, matches []Match) (*scopeIndex, error) {
+	if idx := matches[0].scopes; idx != nil && idx.file == filePath {
+		shared := true
+		for _, match := range matches {
+			shared = shared && match.scopes == idx
+		}
+		if shared {
+			return idx, nil
+		}
+	}
+
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	lang, err := GetTSLanguageFromEnry(filePath, contents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get language: %w", err)
+	}
+	parser := sitter.NewParser()
+	defer parser.Close()
+	parser.SetLanguage(lang)
+	tree, err := parser.ParseCtx(context.Background(), nil, contents)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrParse, err)
+	}
+	defer tree.Close()
+
+	root := tree.RootNode()
+	idx := newScopeIndex(filePath, NewLineIndex(contents))
+	for _, match := range matches {
+		if start, end := idx.matchRange(match); start >= 0 {
+			idx.add(root, start, end)
+		}
+	}
+	return idx, nil
+}
@@ -0,0 +1,246 @@
+package asq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"time"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+var (
+	// ErrFileTooLarge is reported for files skipped because of MaxFileSize.
+	ErrFileTooLarge = errors.New("file exceeds size limit")
+	// ErrFileTimeout is reported for files skipped because parsing or querying
+	// them took longer than FileTimeout.
+	ErrFileTimeout = errors.New("file exceeds time limit")
+	// ErrMatchLimit is reported after the first MaxMatchesPerFile matches of a
+	// file; the remaining matches of that file are not searched for.
+	ErrMatchLimit = errors.New("file exceeds match limit")
+)
+
+// SearchOptions controls how Search and SearchDir visit files.
+type SearchOptions struct {
+	// Index, if set, is consulted to skip files that cannot match the query.
+	Index *Index
+	// MaxCount stops the search after this many matches. Zero means no limit.
+	MaxCount int
+	// MaxFileSize skips files larger than this many bytes. Zero means no limit.
+	MaxFileSize int64
+	// FileTimeout bounds the time spent parsing and querying a single file,
+	// using tree-sitter's parser timeout and cancellation flag. Zero means no limit.
+	FileTimeout time.Duration
+	// MaxMatchesPerFile stops searching a file after this many matches.
+	// Zero means no limit.
+	MaxMatchesPerFile int
+	// Pattern is recorded in Match.Pattern of every match.
+	Pattern string
+	// Scopes records with the matches of each file the code enclosing them,
+	// so that GroupMatches need not parse the file again. The matches then
+	// keep the contents of their file alive.
+	Scopes bool
+	// Walk selects the files SearchDir and SearchPaths search.
+	Walk WalkOptions
+}
+
+// IsSkip reports whether err records a file that was skipped or truncated
+// because of one of the SearchOptions limits, as opposed to a failure.
+func IsSkip(err error) bool {
+	return errors.Is(err, ErrFileTooLarge) || errors.Is(err, ErrFileTimeout) || errors.Is(err, ErrMatchLimit)
+}
+
+// MatchFile returns an iterator over the matches of query in file. Matches are
+// produced as tree-sitter finds them; a file without matches yields nothing.
+// Failures to read or parse the file, or to compile the query, are yielded as
+// a single error, as is ctx.Err() if ctx is done before the file is finished.
+func MatchFile(ctx context.Context, file, query string) iter.Seq2[Match, error] {
+	return func(yield func(Match, error) bool) {
+		queries := newQueryCache(query)
+		defer queries.close()
+		for match, err := range matchFile(ctx, file, queries, SearchOptions{}) {
+			if !yield(match, err) {
+				return
+			}
+		}
+	}
+}
+
+func matchFile(ctx context.Context, file string, queries *queryCache, opts SearchOptions) iter.Seq2[Match, error] {
+	return func(yield func(Match, error) bool) {
+		parent := ctx
+		if opts.FileTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opts.FileTimeout)
+			defer cancel()
+		}
+		// timeout converts the error of an interrupted file into ErrFileTimeout,
+		// unless the caller's own context is the reason
+		timeout := func(err error) error {
+			if parent.Err() != nil {
+				return parent.Err()
+			}
+			return fmt.Errorf("%w (%v): %v", ErrFileTimeout, opts.FileTimeout, err)
+		}
+
+		if opts.MaxFileSize > 0 {
+			if info, err := os.Stat(file); err == nil && info.Size() > opts.MaxFileSize {
+				yield(Match{}, fmt.Errorf("%w (%d > %d bytes)", ErrFileTooLarge, info.Size(), opts.MaxFileSize))
+				return
+			}
+		}
+
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			yield(Match{}, fmt.Errorf("failed to read file: %w", err))
+			return
+		}
+
+		lang, err := GetTSLanguageFromEnry(file, contents)
+		if err != nil {
+			yield(Match{}, fmt.Errorf("failed to get language: %w", err))
+			return
+		}
+
+		q, err := queries.get(lang)
+		if err != nil {
+			yield(Match{}, err)
+			return
+		}
+
+		parser := sitter.NewParser()
+		defer parser.Close()
+		parser.SetLanguage(lang)
+		if opts.FileTimeout > 0 {
+			parser.SetOperationLimit(int(opts.FileTimeout.Microseconds()))
+		}
+		tree, err := parser.ParseCtx(ctx, nil, contents)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, sitter.ErrOperationLimit) {
+				err = timeout(err)
+			} else {
+				err = fmt.Errorf("%w: %v", ErrParse, err)
+			}
+			yield(Match{}, err)
+			return
+		}
+		defer tree.Close()
+
+		var scopes *scopeIndex
+		if opts.Scopes {
+			scopes = newScopeIndex(file, NewLineIndex(contents))
+		}
+		count := 0
+		for match := range queryMatches(q, tree.RootNode(), contents, scopes) {
+			if err := ctx.Err(); err != nil {
+				yield(Match{}, timeout(err))
+				return
+			}
+			if opts.MaxMatchesPerFile > 0 && count >= opts.MaxMatchesPerFile {
+				yield(Match{}, fmt.Errorf("%w (%d)", ErrMatchLimit, opts.MaxMatchesPerFile))
+				return
+			}
+			match.File = file
+			match.Pattern = opts.Pattern
+			if !yield(match, nil) {
+				return
+			}
+			count++
+		}
+	}
+}
+
+// Search returns an iterator over the matches of query in each of files, in
+// order. Errors for individual files are yielded alongside the file's path in
+// Match.File and the search continues with the next file unless the caller
+// stops iterating. Files skipped because of a limit in opts are reported with
+// an error for which IsSkip is true. An invalid query or a done ctx ends the
+// search with a single InvalidQueryError or ctx.Err().
+func Search(ctx context.Context, query string, files iter.Seq[string], opts SearchOptions) iter.Seq2[Match, error] {
+	return func(yield func(Match, error) bool) {
+		queries := newQueryCache(query)
+		defer queries.close()
+		kinds := QueryKinds(query)
+		count := 0
+		for file := range files {
+			if err := ctx.Err(); err != nil {
+				yield(Match{}, err)
+				return
+			}
+			if opts.Index != nil {
+				if info, err := os.Stat(file); err == nil {
+					if entry, _, err := opts.Index.Refresh(file, info); err == nil && !entry.MayMatch(kinds) {
+						continue
+					}
+				}
+			}
+			for match, err := range matchFile(ctx, file, queries, opts) {
+				if err != nil {
+					if ctx.Err() != nil {
+						yield(Match{}, ctx.Err())
+						return
+					}
+					match.File = file
+				}
+				if !yield(match, err) {
+					return
+				}
+				if errors.Is(err, ErrInvalidQuery) {
+					return // every other file would fail the same way
+				}
+				if err == nil {
+					count++
+					if opts.MaxCount > 0 && count >= opts.MaxCount {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// SearchDir is like Search over every Go file below root.
+func SearchDir(ctx context.Context, root, query string, opts SearchOptions) iter.Seq2[Match, error] {
+	return SearchPaths(ctx, []string{root}, query, opts)
+}
+
+// SearchPaths is like Search over the Go files WalkGoPaths visits below roots
+// with opts.Walk. A root that cannot be walked is reported after the matches
+// and does not stop the search of the others.
+func SearchPaths(ctx context.Context, roots []string, query string, opts SearchOptions) iter.Seq2[Match, error] {
+	return func(yield func(Match, error) bool) {
+		var walkErrs []error
+		files := func(yieldFile func(string) bool) {
+			for _, root := range roots {
+				stopped := false
+				err := WalkGoPaths([]string{root}, opts.Walk, func(path string, info fs.FileInfo) error {
+					if !yieldFile(path) {
+						stopped = true
+						return filepath.SkipAll
+					}
+					return nil
+				})
+				if stopped {
+					return
+				}
+				if err != nil {
+					walkErrs = append(walkErrs, fmt.Errorf("failed to walk %s: %v", root, err))
+				}
+			}
+		}
+		for match, err := range Search(ctx, query, files, opts) {
+			if !yield(match, err) {
+				return
+			}
+		}
+		for _, err := range walkErrs {
+			if !yield(Match{}, err) {
+				return
+			}
+		}
+	}
+}
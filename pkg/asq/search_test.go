@@ -0,0 +1,186 @@
+package asq_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+func writeSearchFixture(t *testing.T) string {
+	tmpDir, err := os.MkdirTemp("", "asq-search-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	files := map[string]string{
+		"a.go":         "package a\n\nfunc f() {\n\tg()\n\tg()\n}\n",
+		"b/b.go":       "package b\n\nvar x = 1\n",
+		"c/c.go":       "package c\n\nfunc h() { g() }\n",
+		"_asq_skip.go": "package a\n\nfunc q() { g() }\n",
+		"notes.txt":    "g()\n",
+	}
+	for name, src := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	return tmpDir
+}
+
+func TestSearchDir(t *testing.T) {
+	tmpDir := writeSearchFixture(t)
+	defer os.RemoveAll(tmpDir)
+
+	var got []string
+	for match, err := range asq.SearchDir(context.Background(), tmpDir, `(call_expression) @x`, asq.SearchOptions{}) {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		rel, _ := filepath.Rel(tmpDir, match.File)
+		got = append(got, rel)
+	}
+	expected := []string{"a.go", "a.go", filepath.Join("c", "c.go")}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected matches in %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Match %d: expected %s, got %s", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestSearchDirStopsEarly(t *testing.T) {
+	tmpDir := writeSearchFixture(t)
+	defer os.RemoveAll(tmpDir)
+
+	count := 0
+	for range asq.SearchDir(context.Background(), tmpDir, `(call_expression) @x`, asq.SearchOptions{}) {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Errorf("Expected iteration to stop after 1 match, got %d", count)
+	}
+
+	count = 0
+	for range asq.SearchDir(context.Background(), tmpDir, `(call_expression) @x`, asq.SearchOptions{MaxCount: 2}) {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("Expected MaxCount to cap results at 2, got %d", count)
+	}
+}
+
+func TestSearchDirLimits(t *testing.T) {
+	tmpDir := writeSearchFixture(t)
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name     string
+		opts     asq.SearchOptions
+		matches  int
+		expected error
+	}{
+		{
+			name:     "match_limit",
+			opts:     asq.SearchOptions{MaxMatchesPerFile: 1},
+			matches:  2,
+			expected: asq.ErrMatchLimit,
+		},
+		{
+			name:     "size_limit",
+			opts:     asq.SearchOptions{MaxFileSize: 30},
+			matches:  1,
+			expected: asq.ErrFileTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var matches int
+			var skipped []error
+			for match, err := range asq.SearchDir(context.Background(), tmpDir, `(call_expression) @x`, tt.opts) {
+				switch {
+				case err == nil:
+					matches++
+				case asq.IsSkip(err):
+					if filepath.Base(match.File) != "a.go" {
+						t.Errorf("Expected a.go to be skipped, got %s", match.File)
+					}
+					skipped = append(skipped, err)
+				default:
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			if matches != tt.matches || len(skipped) != 1 {
+				t.Fatalf("Expected %d matches and 1 skipped file, got %d and %v", tt.matches, matches, skipped)
+			}
+			if !errors.Is(skipped[0], tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, skipped[0])
+			}
+		})
+	}
+}
+
+func TestSearchDirCancelled(t *testing.T) {
+	tmpDir := writeSearchFixture(t)
+	defer os.RemoveAll(tmpDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, err := range asq.SearchDir(ctx, tmpDir, `(call_expression) @x`, asq.SearchOptions{}) {
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+	}
+}
+
+func TestMatchRangesAndCaptures(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-match-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, "a.go")
+	src := "package a\n\ntype T struct{}\n\nfunc (t *T) M() {\n\tg(func() {\n\t\th()\n\t})\n}\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	matches, err := asq.ValidateTreeSitterQuery(file, `(call_expression function: (identifier) @_asq_F) @x`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("Expected 2 matches, got %d", len(matches))
+	}
+
+	outer := matches[0]
+	if outer.Row != 6 || outer.Col != 1 || outer.EndRow != 8 || outer.EndCol != 3 {
+		t.Errorf("Unexpected range %d:%d-%d:%d", outer.Row, outer.Col, outer.EndRow, outer.EndCol)
+	}
+	if got := src[outer.StartByte:outer.EndByte]; got != outer.Text {
+		t.Errorf("Byte range %q does not match text %q", got, outer.Text)
+	}
+	if outer.Function != "(*T).M" {
+		t.Errorf("Expected enclosing function (*T).M, got %q", outer.Function)
+	}
+
+	inner := matches[1]
+	if len(inner.Captures) != 1 {
+		t.Fatalf("Expected 1 capture, got %+v", inner.Captures)
+	}
+	fn := inner.Captures[0]
+	if fn.Name != "_asq_F" || fn.Text != "h" || fn.Row != 7 || fn.Col != 2 {
+		t.Errorf("Unexpected capture %+v", fn)
+	}
+}
@@ -0,0 +1,175 @@
+package asq
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// contextLines is the number of lines shown around a match that lies outside
+// every declaration.
+const contextLines = 5
+
+// largeFuncLitLines is the number of lines from which a function literal,
+// such as the body of a subtest, is a group of its own.
+const largeFuncLitLines = 10
+
+// Boundary selects how far a snippet group extends around its matches.
+type Boundary int
+
+const (
+	// BoundaryFunction groups matches by top-level function or method, and
+	// matches outside functions by top-level declaration.
+	BoundaryFunction Boundary = iota
+	// BoundaryDeclaration groups matches by the smallest enclosing
+	// declaration: a function, a var, const or type declaration, a type in a
+	// type (...) block, or a function literal of at least ten lines.
+	BoundaryDeclaration
+	// BoundaryStatement groups matches by the smallest enclosing statement,
+	// and matches outside statements as BoundaryDeclaration does.
+	BoundaryStatement
+)
+
+var boundaryNames = []string{"function", "declaration", "statement"}
+
+func (b Boundary) String() string {
+	if b < 0 || int(b) >= len(boundaryNames) {
+		return fmt.Sprintf("Boundary(%d)", int(b))
+	}
+	return boundaryNames[b]
+}
+
+// ParseBoundary returns the Boundary named s: "function", "declaration" or
+// "statement".
+func ParseBoundary(s string) (Boundary, error) {
+	for i, name := range boundaryNames {
+		if s == name {
+			return Boundary(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown group boundary %q (expected %s)", s, strings.Join(boundaryNames, ", "))
+}
+
+// MatchGroup represents a group of matches that should be displayed together
+type MatchGroup struct {
+	FilePath    string
+	StartLine   int
+	EndLine     int
+	Snippet     string
+	Kind        string // What the group shows, e.g. "func", "method", "var", "type", "func literal" or "statement"
+	IsFunction  bool
+	FunctionPos token.Pos // Used for sorting function groups: the offset of the function plus one
+	Matches     []Match   // The matches within the group
+	Definition  string    // For groups from DefinitionGroups, the name defined
+	References  []Match   // For groups from DefinitionGroups, the matches using the definition
+}
+
+// GroupMatchesForCursorDedup takes a list of matches for a file and groups them
+// by their containing functions or root-level context. Returns groups sorted by position.
+func GroupMatchesForCursorDedup(filePath string, matches []Match) ([]MatchGroup, error) {
+	return GroupMatches(filePath, matches, BoundaryFunction)
+}
+
+// GetSnippetForMatch returns the code snippet for a given match, including context.
+// If the match is within a function, returns the entire function, and if it is
+// within another declaration, the entire declaration.
+// Otherwise, returns 5 lines before and after the match.
+func GetSnippetForMatch(filePath string, match Match) (string, error) {
+	groups, err := GroupMatches(filePath, []Match{match}, BoundaryFunction)
+	if err != nil || len(groups) == 0 {
+		return "", err
+	}
+	return groups[0].Snippet, nil
+}
+
+// GroupMatches groups the matches of a file by their enclosing code, as
+// selected by boundary. Each line of the file appears in at most one group:
+// groups that would overlap, such as those of nested statements, are merged.
+// Groups are sorted by position. Matches found by a search with
+// SearchOptions.Scopes carry the enclosing code recorded from its syntax
+// tree; for other matches the file is parsed again.
+func GroupMatches(filePath string, matches []Match, boundary Boundary) ([]MatchGroup, error) {
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	idx, err := matchScopes(filePath, matches)
+	if err != nil {
+		return nil, err
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	lines := bytes.Split(idx.lines.contents, []byte("\n"))
+
+	// Group matches by the line range of their container
+	type span struct {
+		start, end int // lines, inclusive
+		kind       string
+		fn         *scope
+		matches    []Match
+	}
+	spans := make(map[[2]int]*span)
+	for _, match := range matches {
+		start, end := idx.matchRange(match)
+		container, kind := idx.container(start, end, boundary)
+
+		var first, last int
+		if container != nil {
+			first, last = container.startLine, container.endLine
+		} else {
+			first, last = idx.contextWindow(lines, match.Row)
+			kind = "lines"
+		}
+		key := [2]int{first, last}
+		if spans[key] == nil {
+			spans[key] = &span{start: first, end: last, kind: kind}
+			if container != nil && container.role == roleFunc && kind != "statement" {
+				spans[key].fn = container
+			}
+		}
+		spans[key].matches = append(spans[key].matches, match)
+	}
+
+	sorted := make([]*span, 0, len(spans))
+	for _, s := range spans {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].start != sorted[j].start {
+			return sorted[i].start < sorted[j].start
+		}
+		return sorted[i].end > sorted[j].end // outer containers first
+	})
+
+	// Merge overlapping spans into the outermost one
+	var merged []*span
+	for _, s := range sorted {
+		if n := len(merged); n > 0 && s.start <= merged[n-1].end {
+			outer := merged[n-1]
+			outer.end = max(outer.end, s.end)
+			outer.matches = append(outer.matches, s.matches...)
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	groups := make([]MatchGroup, 0, len(merged))
+	for _, s := range merged {
+		sort.SliceStable(s.matches, func(i, j int) bool { return s.matches[i].StartByte < s.matches[j].StartByte })
+		group := MatchGroup{
+			FilePath:  filePath,
+			StartLine: s.start,
+			EndLine:   s.end,
+			Snippet:   string(bytes.Join(lines[s.start-1:s.end], []byte("\n"))),
+			Kind:      s.kind,
+			Matches:   s.matches,
+		}
+		if s.fn != nil {
+			group.IsFunction = true
+			group.FunctionPos = token.Pos(s.fn.start + 1)
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
@@ -0,0 +1,244 @@
+package asq_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+const groupingSource = `package a
+
+var (
+	first  = g(1)
+	second = 2
+)
+
+type (
+	A struct{}
+	B struct {
+		f func() int
+	}
+)
+
+func TestTable(t *testing.T) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.ok {
+				g(2)
+			}
+			x := 1
+			_ = x
+			_ = x
+			_ = x
+			_ = x
+		})
+	}
+}
+
+func other() {
+	g(3)
+}
+
+// trailing comment g(4)
+`
+
+// searchScopes returns the matches of query in file, found by a search that
+// records the code enclosing them for GroupMatches.
+func searchScopes(tb testing.TB, file, query string) []asq.Match {
+	tb.Helper()
+	var matches []asq.Match
+	for m, err := range asq.Search(context.Background(), query, slices.Values([]string{file}), asq.SearchOptions{Scopes: true}) {
+		if err != nil {
+			tb.Fatalf("Unexpected error: %v", err)
+		}
+		matches = append(matches, m)
+	}
+	return matches
+}
+
+func TestGroupMatches(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-snippet-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, "a_test.go")
+	if err := os.WriteFile(file, []byte(groupingSource), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	query := `[(call_expression function: (identifier) @fn (#eq? @fn "g")) (field_declaration)] @x`
+	matches := searchScopes(t, file, query)
+	// Without recorded scopes GroupMatches parses the file instead
+	plain, err := asq.ValidateTreeSitterQuery(file, query)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The comment is not code, so point a match at it by line alone. Unlike
+	// the matches of the search, it makes GroupMatches parse the file.
+	withComment := append(matches[:len(matches):len(matches)], asq.Match{File: file, Row: 34})
+
+	type group struct {
+		Start, End int
+		Kind       string
+		Matches    int
+	}
+	tests := []struct {
+		boundary asq.Boundary
+		expected []group
+	}{
+		{
+			boundary: asq.BoundaryFunction,
+			expected: []group{
+				{3, 6, "var", 1},
+				{8, 13, "type", 1},
+				{15, 28, "func", 1},
+				{30, 32, "func", 1},
+				{34, 34, "lines", 1},
+			},
+		},
+		{
+			boundary: asq.BoundaryDeclaration,
+			expected: []group{
+				{3, 6, "var", 1},
+				{10, 12, "type", 1},
+				{17, 26, "func literal", 1},
+				{30, 32, "func", 1},
+				{34, 34, "lines", 1},
+			},
+		},
+		{
+			boundary: asq.BoundaryStatement,
+			expected: []group{
+				{3, 6, "var", 1},
+				{10, 12, "type", 1},
+				{19, 19, "statement", 1},
+				{31, 31, "statement", 1},
+				{34, 34, "lines", 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.boundary.String(), func(t *testing.T) {
+			for _, ms := range [][]asq.Match{matches, plain, withComment} {
+				groups, err := asq.GroupMatches(file, ms, tt.boundary)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				var got []group
+				for _, g := range groups {
+					got = append(got, group{g.StartLine, g.EndLine, g.Kind, len(g.Matches)})
+				}
+				expected := tt.expected[:len(ms)] // each match is a group of its own
+				if !reflect.DeepEqual(got, expected) {
+					t.Errorf("Expected groups %v, got %v", expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestGroupMatchesMergesNested(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-snippet-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, "a.go")
+	src := "package a\n\nfunc f() {\n\tif g(1) {\n\t\tg(2)\n\t}\n}\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	matches, err := asq.ValidateTreeSitterQuery(file, `(call_expression) @x`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	groups, err := asq.GroupMatches(file, matches, asq.BoundaryStatement)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(groups) != 1 || groups[0].StartLine != 4 || groups[0].EndLine != 6 || len(groups[0].Matches) != 2 {
+		t.Errorf("Expected the statements to merge into lines 4-6, got %+v", groups)
+	}
+}
+
+// generatedSource returns a file like those produced by code generators: many
+// short functions and a long table, with a match in every function.
+func generatedSource(funcs int) string {
+	var sb strings.Builder
+	sb.WriteString("package a\n\nvar table = []int{\n")
+	for i := 0; i < funcs; i++ {
+		fmt.Fprintf(&sb, "\t%d,\n", i)
+	}
+	sb.WriteString("}\n")
+	for i := 0; i < funcs; i++ {
+		fmt.Fprintf(&sb, "\nfunc f%d(t *T) {\n\tif t.ok {\n\t\tg(%d)\n\t}\n\tt.run(func() {\n\t\tt.x = table[%d]\n\t})\n}\n", i, i, i)
+	}
+	return sb.String()
+}
+
+func BenchmarkGroupMatches(b *testing.B) {
+	tmpDir, err := os.MkdirTemp("", "asq-snippet-*")
+	if err != nil {
+		b.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, "generated.go")
+	if err := os.WriteFile(file, []byte(generatedSource(2000)), 0644); err != nil {
+		b.Fatalf("Failed to write test file: %v", err)
+	}
+	query := `(call_expression function: (identifier) @fn (#eq? @fn "g")) @x`
+	matches := searchScopes(b, file, query)
+	// Matches of a search that did not record their scopes, which
+	// GroupMatches must parse the file for
+	reparsed, err := asq.ValidateTreeSitterQuery(file, query)
+	if err != nil {
+		b.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, boundary := range []asq.Boundary{asq.BoundaryFunction, asq.BoundaryDeclaration, asq.BoundaryStatement} {
+		b.Run(boundary.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := asq.GroupMatches(file, matches, boundary); err != nil {
+					b.Fatalf("Unexpected error: %v", err)
+				}
+			}
+		})
+		b.Run(boundary.String()+"/reparsed", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := asq.GroupMatches(file, reparsed, boundary); err != nil {
+					b.Fatalf("Unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSearchGenerated(b *testing.B) {
+	tmpDir, err := os.MkdirTemp("", "asq-snippet-*")
+	if err != nil {
+		b.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, "generated.go")
+	if err := os.WriteFile(file, []byte(generatedSource(2000)), 0644); err != nil {
+		b.Fatalf("Failed to write test file: %v", err)
+	}
+	for i := 0; i < b.N; i++ {
+		if _, err := asq.ValidateTreeSitterQuery(file, `(call_expression function: (identifier) @fn (#eq? @fn "g")) @x`); err != nil {
+			b.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package patterns
+
+func asq_inst_foo() {
+	//asq_start
+	e.Inst().Foo()
+	//asq_end
+}
@@ -0,0 +1,12 @@
+package patterns
+
+func useInstFoo(e, x Thingy) bool {
+	// asq:expect
+	if e.Inst().Foo() {
+		return e.Inst().Foo() // asq:expect
+	}
+	// asq:expect-not
+	e.Foo()
+	x.Other().Bar() // asq:expect-not
+	return false
+}
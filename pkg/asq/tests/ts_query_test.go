@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,6 +14,7 @@ func TestMetaqQuery(t *testing.T) {
 	tests := []struct {
 		name     string
 		code     string
+		rewrite  bool // build the query with ExtractRewriteQuery
 		expected string
 	}{
 		{
@@ -43,6 +45,41 @@ func asq_query2() {
 }`,
 			expected: `(call_expression function: (selector_expression operand: (call_expression function: (selector_expression operand: (identifier) field: (field_identifier) @field (#eq? @field "Inst")) arguments: (argument_list)) field: (field_identifier) @field (#eq? @field "Foo")) arguments: (argument_list)) @x`,
 		},
+		{
+			name: "metavariable_match",
+			code: `package example1
+type Thingy1 struct{}
+func (t Thingy1) Inst() Thingy1 { return t }
+func (t Thingy1) Foo() bool { return true }
+var e = new(Thingy1)
+func asq_query2() {
+	//asq_start
+	_asq_X.Inst().Foo()
+	//asq_end
+}`,
+			expected: `(call_expression function: (selector_expression operand: (call_expression function: (selector_expression operand: (identifier) @_asq_X field: (field_identifier) @field (#eq? @field "Inst")) arguments: (argument_list)) field: (field_identifier) @field (#eq? @field "Foo")) arguments: (argument_list)) @x`,
+		},
+		{
+			name: "metavariable_argument",
+			code: `package example1
+func asq_query2() {
+	//asq_start
+	ioutil.ReadFile(_asq_X, "b")
+	//asq_end
+}`,
+			expected: `(call_expression function: (selector_expression operand: (identifier) @name (#eq? @name "ioutil") field: (field_identifier) @field (#eq? @field "ReadFile")) arguments: (argument_list)) @x`,
+		},
+		{
+			name: "metavariable_argument_rewrite",
+			code: `package example1
+func asq_query2() {
+	//asq_start
+	ioutil.ReadFile(_asq_X, "b")
+	//asq_end
+}`,
+			rewrite:  true,
+			expected: `(call_expression function: (selector_expression operand: (identifier) @name (#eq? @name "ioutil") field: (field_identifier) @field (#eq? @field "ReadFile")) arguments: (argument_list . (_) @_asq_X . (_) @value (#eq? @value "\"b\"") .)) @x`,
+		},
 		{
 			name: "exact_match_with_different_receiver",
 			code: `package example1
@@ -121,6 +158,9 @@ func Example() {
 
 			// Run asq
 			query, err := asq.ExtractTreeSitterQuery(testFile)
+			if tt.rewrite {
+				query, err = asq.ExtractRewriteQuery(context.Background(), testFile)
+			}
 			if err != nil {
 				t.Fatalf("Failed to extract query: %v", err)
 			}
@@ -1,363 +1,270 @@
 package asq
 
 import (
-	"errors"
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/go-enry/go-enry/v2"
 	"github.com/smacker/go-tree-sitter"
 	"github.com/smacker/go-tree-sitter/golang"
 	"go/ast"
-	"go/parser"
-	"go/token"
-	"os"
-	"sort"
+	"iter"
+	"regexp"
 	"strings"
 )
 
 // ConvertToTreeSitterQuery converts a Go AST node to a tree-sitter query string
 func ConvertToTreeSitterQuery(node ast.Node, p *QueryContext) (string, error) {
-	var sb strings.Builder
-	metaqNode := BuildAsqNode(node, p)
-	if err := metaqNode.WriteTreeSitterQuery(&sb); err != nil {
-		return "", err
-	}
-	if _, err := sb.WriteString(" @x"); err != nil {
-		return "", err
-	}
-	return sb.String(), nil
+	return nodeQuery(BuildAsqNode(node, p))
 }
 
-var (
-	ErrUnsupportedLang = errors.New("unsupported language")
-)
-
 // GetTSLanguageFromEnry detects the language of a file using go-enry and returns
 // the corresponding tree-sitter language parser. Currently only supports Go.
 func GetTSLanguageFromEnry(filename string, contents []byte) (*sitter.Language, error) {
 	lang := enry.GetLanguage(filename, contents)
 	if lang == "" {
-		return nil, errors.New("could not detect language")
+		return nil, fmt.Errorf("could not detect language: %w", ErrUnsupportedLang)
 	}
 	switch lang {
 	case "Go":
 		return golang.GetLanguage(), nil
 	default:
-		return nil, ErrUnsupportedLang
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedLang, lang)
 	}
 }
 
-// Match represents a single tree-sitter query match
+// Match represents a single tree-sitter query match. Rows are 1-based and
+// columns are 0-based byte offsets within the line.
 type Match struct {
-	Row  int
-	Col  int
-	Code string
+	File      string
+	Row       int
+	Col       int
+	EndRow    int
+	EndCol    int
+	StartByte int
+	EndByte   int
+	Code      string    // matched code, trimmed for display
+	Text      string    // exact matched source text
+	Function  string    // name of the enclosing function or method, if any
+	Pattern   string    // name of the pattern that produced the match, if known
+	Captures  []Capture // metavariables bound by the match
+
+	scopes *scopeIndex // code enclosing the matches of the file, if recorded for GroupMatches
 }
 
-// MatchGroup represents a group of matches that should be displayed together
-type MatchGroup struct {
-	FilePath    string
-	StartLine   int
-	EndLine     int
-	Snippet     string
-	IsFunction  bool
-	FunctionPos token.Pos // Used for sorting function groups
+// Capture is a node captured by name within a match.
+type Capture struct {
+	Name      string
+	Row       int
+	Col       int
+	EndRow    int
+	EndCol    int
+	StartByte int
+	EndByte   int
+	Text      string
 }
 
-// GroupMatchesForCursorDedup takes a list of matches for a file and groups them
-// by their containing functions or root-level context. Returns groups sorted by position.
-func GroupMatchesForCursorDedup(filePath string, matches []Match) ([]MatchGroup, error) {
-	if len(matches) == 0 {
-		return nil, nil
-	}
-
-	// Read and parse the file
-	contents, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %v", err)
-	}
-
-	fset := token.NewFileSet()
-	astFile, err := parser.ParseFile(fset, filePath, nil, parser.ParseComments)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse file: %v", err)
-	}
-
-	// Map to track matches by function
-	functionGroups := make(map[*ast.FuncDecl][]Match)
-	var rootMatches []Match
-
-	// Find containing functions for all matches
-	for _, match := range matches {
-		var foundFunc *ast.FuncDecl
-		ast.Inspect(astFile, func(n ast.Node) bool {
-			if fd, ok := n.(*ast.FuncDecl); ok {
-				startPos := fset.Position(fd.Pos())
-				endPos := fset.Position(fd.End())
-				if match.Row >= startPos.Line && match.Row <= endPos.Line {
-					foundFunc = fd
-					return false
-				}
-			}
-			return true
-		})
+// ValidateTreeSitterQuery executes a tree-sitter query directly on the given file
+// returns all matches with their line numbers, column numbers, and matched code.
+// A file without matches returns an empty result and a nil error; failures are
+// reported with ErrUnsupportedLang, ErrParse, an InvalidQueryError or the
+// underlying I/O error.
+func ValidateTreeSitterQuery(file, query string) ([]Match, error) {
+	return ValidateTreeSitterQueryContext(context.Background(), file, query)
+}
 
-		if foundFunc != nil {
-			functionGroups[foundFunc] = append(functionGroups[foundFunc], match)
-		} else {
-			rootMatches = append(rootMatches, match)
+// ValidateTreeSitterQueryContext is like ValidateTreeSitterQuery but stops
+// parsing and matching once ctx is done.
+func ValidateTreeSitterQueryContext(ctx context.Context, file, query string) ([]Match, error) {
+	var matches []Match
+	for match, err := range MatchFile(ctx, file, query) {
+		if err != nil {
+			return nil, err
 		}
+		matches = append(matches, match)
 	}
+	return matches, nil
+}
 
-	// Create result groups
-	var groups []MatchGroup
-	lines := strings.Split(string(contents), "\n")
-
-	// Handle root-level matches first
-	if len(rootMatches) > 0 {
-		// Sort root matches by line number
-		sort.Slice(rootMatches, func(i, j int) bool {
-			return rootMatches[i].Row < rootMatches[j].Row
-		})
-
-		// Find min and max lines
-		minLine := rootMatches[0].Row
-		maxLine := rootMatches[len(rootMatches)-1].Row
-
-		// Add context lines
-		startLine := minLine - 5
-		if startLine < 1 {
-			startLine = 1
+// predicateCapturePattern matches a capture followed by an #eq? or #not-eq?
+// predicate on itself, such as `@name (#eq? @name "`.
+var predicateCapturePattern = regexp.MustCompile(`@([\w.-]+)(\s*\(#(?:not-)?eq\?\s+)@([\w.-]+)(\s)`)
+
+// distinctPredicateCaptures gives every capture that is compared to a string
+// by its own predicate a name of its own, when the name is used this way more
+// than once. Generated queries name every constrained identifier @name, and
+// tree-sitter applies a predicate to every capture of its name in the match,
+// so (#eq? @name "a") would otherwise also be checked against the @name
+// captured for "b".
+func distinctPredicateCaptures(query string) string {
+	counts := make(map[string]int)
+	for _, m := range predicateCapturePattern.FindAllStringSubmatch(query, -1) {
+		if m[1] == m[3] {
+			counts[m[1]]++
 		}
-		endLine := maxLine + 5
-		if endLine > len(lines) {
-			endLine = len(lines)
+	}
+	seen := make(map[string]int)
+	return predicateCapturePattern.ReplaceAllStringFunc(query, func(s string) string {
+		m := predicateCapturePattern.FindStringSubmatch(s)
+		if m[1] != m[3] || counts[m[1]] < 2 {
+			return s
 		}
+		seen[m[1]]++
+		name := fmt.Sprintf("%s.%d", m[1], seen[m[1]])
+		return "@" + name + m[2] + "@" + name + m[4]
+	})
+}
 
-		// Extract lines, excluding any that are part of functions
-		var contextLines []string
-		for i := startLine - 1; i < endLine; i++ {
-			isInFunction := false
-			ast.Inspect(astFile, func(n ast.Node) bool {
-				if fd, ok := n.(*ast.FuncDecl); ok {
-					startPos := fset.Position(fd.Pos())
-					endPos := fset.Position(fd.End())
-					if i+1 >= startPos.Line && i+1 <= endPos.Line {
-						isInFunction = true
-						return false
-					}
-				}
-				return true
-			})
-			if !isInFunction {
-				contextLines = append(contextLines, lines[i])
-			}
+// queryMatches runs a compiled query over an already parsed tree and yields
+// a Match for every node captured as @x. The lines of the file are indexed
+// once the first match is found. If scopes is not nil, the code enclosing
+// each match is recorded in it.
+func queryMatches(q *sitter.Query, root *sitter.Node, contents []byte, scopes *scopeIndex) iter.Seq[Match] {
+	return func(yield func(Match) bool) {
+		var lines *LineIndex
+		if scopes != nil {
+			lines = scopes.lines
 		}
-
-		if len(contextLines) > 0 {
-			// Trim leading and trailing empty lines while preserving internal spacing
-			start := 0
-			end := len(contextLines)
-			
-			// Find first non-empty line
-			for start < end && strings.TrimSpace(contextLines[start]) == "" {
-				start++
+		qc := sitter.NewQueryCursor()
+		defer qc.Close()
+		qc.Exec(q, root)
+
+		for {
+			match, ok := qc.NextMatch()
+			if !ok {
+				return
 			}
-			
-			// Find last non-empty line
-			for end > start && strings.TrimSpace(contextLines[end-1]) == "" {
-				end--
+			// Only matches satisfying the predicates keep their captures
+			if match = qc.FilterPredicates(match, contents); len(match.Captures) == 0 {
+				continue
 			}
-			
-			if start < end {
-				groups = append(groups, MatchGroup{
-					FilePath:   filePath,
-					StartLine:  startLine + start,
-					EndLine:    startLine + end - 1,
-					Snippet:    strings.Join(contextLines[start:end], "\n"),
-					IsFunction: false,
-				})
+			for _, c := range match.Captures {
+				if q.CaptureNameForId(c.Index) == "x" {
+					if lines == nil {
+						lines = NewLineIndex(contents)
+					}
+					m := newMatch(c.Node, lines)
+					m.Captures = newCaptures(q, match, lines)
+					if scopes != nil {
+						scopes.add(root, int(c.Node.StartByte()), int(c.Node.EndByte()))
+						m.scopes = scopes
+					}
+					if !yield(m) {
+						return
+					}
+				}
 			}
 		}
 	}
-
-	// Handle function groups
-	for fd := range functionGroups {
-		startPos := fset.Position(fd.Pos())
-		endPos := fset.Position(fd.End())
-		functionLines := lines[startPos.Line-1:endPos.Line]
-		groups = append(groups, MatchGroup{
-			FilePath:    filePath,
-			StartLine:   startPos.Line,
-			EndLine:     endPos.Line,
-			Snippet:     strings.Join(functionLines, "\n"),
-			IsFunction:  true,
-			FunctionPos: fd.Pos(),
-		})
-	}
-
-	// Sort groups by position (functions first, then root)
-	sort.Slice(groups, func(i, j int) bool {
-		if groups[i].IsFunction && groups[j].IsFunction {
-			return groups[i].FunctionPos < groups[j].FunctionPos
-		}
-		if groups[i].IsFunction {
-			return true
-		}
-		if groups[j].IsFunction {
-			return false
-		}
-		return groups[i].StartLine < groups[j].StartLine
-	})
-
-	return groups, nil
 }
 
-// ValidateTreeSitterQuery executes a tree-sitter query directly on the given file
-// returns all matches with their line numbers, column numbers, and matched code
-func ValidateTreeSitterQuery(file, query string) ([]Match, error) {
-	contents, err := os.ReadFile(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %v", err)
-	}
-
-	lang, err := GetTSLanguageFromEnry(file, contents)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get language: %v", err)
-	}
-
-	parser := sitter.NewParser()
-	parser.SetLanguage(lang)
-	tree := parser.Parse(nil, contents)
-	root := tree.RootNode()
-
-	q, err := sitter.NewQuery([]byte(query), lang)
-	if err != nil {
-		return nil, fmt.Errorf("invalid query: %v", err)
+// newMatch builds a Match for a captured node. Positions are derived from byte
+// offsets rather than the node's points so that they stay correct for trees
+// that were updated incrementally.
+func newMatch(node *sitter.Node, lines *LineIndex) Match {
+	contents := lines.contents
+	startByte := int(node.StartByte())
+
+	// Get the line containing the node
+	row, col := lines.Position(startByte, ColumnBytes)
+	lineStart := startByte - col
+	lineEnd := bytes.IndexByte(contents[startByte:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(contents)
+	} else {
+		lineEnd += startByte
 	}
-	defer q.Close()
-
-	qc := sitter.NewQueryCursor()
-	defer qc.Close()
-	qc.Exec(q, root)
-
-	var matches []Match
-	for {
-		match, ok := qc.NextMatch()
-		if !ok {
-			break
-		}
-		for _, c := range match.Captures {
-			if q.CaptureNameForId(c.Index) == "x" {
-				row := int(c.Node.StartPoint().Row) + 1
-				col := int(c.Node.StartPoint().Column)
-				// Get the complete node content
-				nodeContent := string(contents[c.Node.StartByte():c.Node.EndByte()])
-
-				// Get the line containing the node
-				lineStart := 0
-				for i := 0; i < int(c.Node.StartPoint().Row); i++ {
-					lineStart = strings.IndexByte(string(contents[lineStart:]), '\n') + lineStart + 1
-				}
-				lineEnd := strings.IndexByte(string(contents[lineStart:]), '\n')
-				if lineEnd == -1 {
-					lineEnd = len(contents)
-				} else {
-					lineEnd += lineStart
-				}
-
-				// Extract the complete line for context
-				fullLine := string(contents[lineStart:lineEnd])
-
-				var finalCode string
-				if strings.Contains(fullLine, "/***/") {
-					// For lines with wildcards, use the complete line
-					finalCode = strings.TrimSpace(fullLine)
-				} else if strings.Contains(nodeContent, "\n") {
-					// For multiline nodes (like function declarations), use the node content
-					lines := strings.Split(nodeContent, "\n")
-					for i := range lines {
-						lines[i] = strings.TrimRight(lines[i], " \t\r\n")
-					}
-					finalCode = strings.Join(lines, "\n")
-				} else {
-					// For single-line nodes without wildcards, use the node content
-					finalCode = strings.TrimSpace(nodeContent)
-				}
 
-				matches = append(matches, Match{
-					Row:  row,
-					Col:  col,
-					Code: finalCode,
-				})
-			}
+	// Get the complete node content
+	nodeContent := string(contents[node.StartByte():node.EndByte()])
+
+	// Extract the complete line for context
+	fullLine := string(contents[lineStart:lineEnd])
+
+	var finalCode string
+	if strings.Contains(fullLine, "/***/") {
+		// For lines with wildcards, use the complete line
+		finalCode = strings.TrimSpace(fullLine)
+	} else if strings.Contains(nodeContent, "\n") {
+		// For multiline nodes (like function declarations), use the node content
+		lines := strings.Split(nodeContent, "\n")
+		for i := range lines {
+			lines[i] = strings.TrimRight(lines[i], " \t\r\n")
 		}
+		finalCode = strings.Join(lines, "\n")
+	} else {
+		// For single-line nodes without wildcards, use the node content
+		finalCode = strings.TrimSpace(nodeContent)
 	}
 
-	if len(matches) == 0 {
-		return nil, fmt.Errorf("no match found for capture @x")
+	endRow, endCol := lines.Position(int(node.EndByte()), ColumnBytes)
+	return Match{
+		Row:       row,
+		Col:       col,
+		EndRow:    endRow,
+		EndCol:    endCol,
+		StartByte: startByte,
+		EndByte:   int(node.EndByte()),
+		Code:      finalCode,
+		Text:      nodeContent,
+		Function:  enclosingFunction(node, contents),
 	}
-
-	return matches, nil
 }
 
-// GetSnippetForMatch returns the code snippet for a given match, including context.
-// If the match is within a function, returns the entire function.
-// Otherwise, returns 5 lines before and after the match.
-func GetSnippetForMatch(filePath string, match Match) (string, error) {
-	// Read the file contents
-	contents, err := os.ReadFile(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to read file: %v", err)
-	}
-
-	// Parse the file for AST analysis
-	fset := token.NewFileSet()
-	astFile, err := parser.ParseFile(fset, filePath, nil, parser.ParseComments)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse file: %v", err)
+// newCaptures returns the metavariables bound by a query match, in query
+// order. The captures asq uses internally, @x and those its predicates
+// compare, are left out.
+func newCaptures(q *sitter.Query, match *sitter.QueryMatch, lines *LineIndex) []Capture {
+	var captures []Capture
+	for _, c := range match.Captures {
+		name := q.CaptureNameForId(c.Index)
+		if !IsMetavariable(name) {
+			continue
+		}
+		row, col := lines.Position(int(c.Node.StartByte()), ColumnBytes)
+		endRow, endCol := lines.Position(int(c.Node.EndByte()), ColumnBytes)
+		captures = append(captures, Capture{
+			Name:      name,
+			Row:       row,
+			Col:       col,
+			EndRow:    endRow,
+			EndCol:    endCol,
+			StartByte: int(c.Node.StartByte()),
+			EndByte:   int(c.Node.EndByte()),
+			Text:      string(lines.contents[c.Node.StartByte():c.Node.EndByte()]),
+		})
 	}
+	return captures
+}
 
-	// Find if the match is within a function
-	var containingFunc *ast.FuncDecl
-	ast.Inspect(astFile, func(n ast.Node) bool {
-		if fd, ok := n.(*ast.FuncDecl); ok {
-			// Get the position information
-			startPos := fset.Position(fd.Pos())
-			endPos := fset.Position(fd.End())
-			
-			// Check if match.Row is within this function's lines
-			if match.Row >= startPos.Line && match.Row <= endPos.Line {
-				containingFunc = fd
-				return false // Stop traversal
+// enclosingFunction returns the name of the function or method declaration
+// containing node, formatted like Go's runtime does: "F", "T.M" or "(*T).M".
+func enclosingFunction(node *sitter.Node, contents []byte) string {
+	for n := node; n != nil && !n.IsNull(); n = n.Parent() {
+		switch n.Type() {
+		case "function_declaration":
+			if name := n.ChildByFieldName("name"); name != nil {
+				return name.Content(contents)
+			}
+		case "method_declaration":
+			name := n.ChildByFieldName("name")
+			if name == nil {
+				return ""
 			}
+			recv := n.ChildByFieldName("receiver")
+			if recv == nil || recv.NamedChildCount() == 0 {
+				return name.Content(contents)
+			}
+			recvType := recv.NamedChild(0).ChildByFieldName("type")
+			if recvType == nil {
+				return name.Content(contents)
+			}
+			typeName := recvType.Content(contents)
+			if strings.HasPrefix(typeName, "*") {
+				return "(" + typeName + ")." + name.Content(contents)
+			}
+			return typeName + "." + name.Content(contents)
 		}
-		return true
-	})
-
-	// Split content into lines for processing
-	lines := strings.Split(string(contents), "\n")
-
-	if containingFunc != nil {
-		// Get the entire function text
-		startPos := fset.Position(containingFunc.Pos())
-		endPos := fset.Position(containingFunc.End())
-		
-		// Extract function lines (convert to 0-based index)
-		functionLines := lines[startPos.Line-1:endPos.Line]
-		return strings.Join(functionLines, "\n"), nil
-	}
-
-	// If not in a function, get 5 lines before and after
-	startLine := match.Row - 5
-	if startLine < 1 {
-		startLine = 1
 	}
-	endLine := match.Row + 5
-	if endLine > len(lines) {
-		endLine = len(lines)
-	}
-
-	// Extract the lines (convert to 0-based index)
-	contextLines := lines[startLine-1:endLine]
-	return strings.Join(contextLines, "\n"), nil
+	return ""
 }
@@ -0,0 +1,294 @@
+package asq
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// ErrTypeCheck is reported for matches whose edit introduces type errors.
+var ErrTypeCheck = errors.New("edit introduces type errors")
+
+// Verifier type-checks the packages of rewritten files with go/types,
+// importing their dependencies from source, so that edits that break
+// compilation can be rolled back. It caches imported packages between checks
+// and is not safe for concurrent use.
+type Verifier struct {
+	fset     *token.FileSet
+	importer types.ImporterFrom
+	dirs     map[[2]string]string // directory of an import path, by importing directory and path
+}
+
+// NewVerifier returns a Verifier that imports packages from source, which
+// needs no network.
+func NewVerifier() *Verifier {
+	fset := token.NewFileSet()
+	return &Verifier{
+		fset:     fset,
+		importer: importer.ForCompiler(fset, "source", nil).(types.ImporterFrom),
+		dirs:     make(map[[2]string]string),
+	}
+}
+
+// typeError is a type or syntax error found by a check.
+type typeError struct {
+	pos token.Position
+	msg string
+}
+
+func (e typeError) String() string {
+	return fmt.Sprintf("%s: %s", e.pos, e.msg)
+}
+
+// checkUnit is a package with its in-package tests, type-checked together,
+// and its external tests, type-checked against the package as rewritten.
+type checkUnit struct {
+	dir      string
+	files    []string // absolute paths
+	xtests   []string // absolute paths of the external test files
+	rewrites []int    // indices of the rewrites of its files
+}
+
+// Verify type-checks the packages of the files that rewrites change, and
+// returns the rewrites with only the edits that introduce no new type errors.
+// Errors present before the rewrites are ignored. The edits of a file are
+// kept together when possible and otherwise one match at a time, in file
+// order; the edits left out are skipped with ErrTypeCheck and the first new
+// error. A package is checked together with its in-package and external
+// tests, so edits that break their callers there are left out too. Files excluded from their package by build constraints, and those
+// outside a buildable package, are not verified.
+func (v *Verifier) Verify(rewrites []*FileRewrite) ([]*FileRewrite, error) {
+	result := slices.Clone(rewrites)
+	units := make(map[string]*checkUnit)
+	for i, fr := range rewrites {
+		if !fr.Changed() {
+			continue
+		}
+		path, err := filepath.Abs(fr.Path)
+		if err != nil {
+			return nil, err
+		}
+		dir, name := filepath.Split(path)
+		pkg, err := build.Default.ImportDir(dir, 0)
+		if err != nil {
+			continue
+		}
+
+		names := slices.Concat(pkg.GoFiles, pkg.CgoFiles, pkg.TestGoFiles)
+		if !slices.Contains(names, name) && !slices.Contains(pkg.XTestGoFiles, name) {
+			continue
+		}
+		u := units[dir]
+		if u == nil {
+			u = &checkUnit{dir: dir}
+			for _, n := range names {
+				u.files = append(u.files, filepath.Join(dir, n))
+			}
+			for _, n := range pkg.XTestGoFiles {
+				u.xtests = append(u.xtests, filepath.Join(dir, n))
+			}
+			units[dir] = u
+		}
+		u.rewrites = append(u.rewrites, i)
+	}
+
+	keys := make([]string, 0, len(units))
+	for key := range units {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := v.verifyUnit(units[key], result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// verifyUnit replaces the rewrites of u in result with those keeping only the
+// edits that introduce no new type errors.
+func (v *Verifier) verifyUnit(u *checkUnit, result []*FileRewrite) error {
+	paths := make(map[int]string, len(u.rewrites))
+	contents := make(map[string][]byte)
+	for _, i := range u.rewrites {
+		path, err := filepath.Abs(result[i].Path)
+		if err != nil {
+			return err
+		}
+		paths[i] = path
+		contents[path] = result[i].Original
+	}
+	baseline, err := v.check(u, contents)
+	if err != nil {
+		return err
+	}
+	known := make(map[[2]string]int)
+	for _, e := range baseline {
+		known[[2]string{e.pos.Filename, e.msg}]++
+	}
+	// newErrors returns the errors of the contents beyond those of baseline.
+	// Positions change with the edits, so errors are told apart by file and
+	// message alone.
+	newErrors := func() ([]typeError, error) {
+		errs, err := v.check(u, contents)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[[2]string]int)
+		var added []typeError
+		for _, e := range errs {
+			key := [2]string{e.pos.Filename, e.msg}
+			if seen[key]++; seen[key] > known[key] {
+				added = append(added, e)
+			}
+		}
+		return added, nil
+	}
+
+	for _, i := range u.rewrites {
+		contents[paths[i]] = result[i].Rewritten
+	}
+	if errs, err := newErrors(); err != nil || len(errs) == 0 {
+		return err
+	}
+
+	// Add the files one at a time, and the edits of a file that breaks the
+	// package one at a time
+	for _, i := range u.rewrites {
+		contents[paths[i]] = result[i].Original
+	}
+	for _, i := range u.rewrites {
+		fr, path := result[i], paths[i]
+		contents[path] = fr.Rewritten
+		errs, err := newErrors()
+		if err != nil {
+			return err
+		}
+		if len(errs) == 0 {
+			continue
+		}
+
+		var kept []Edit
+		reasons := make(map[[2]int]error)
+		for _, e := range fr.Edits {
+			candidate := append(slices.Clip(kept), e)
+			rewritten, err := fr.With(candidate)
+			if err == nil {
+				contents[path] = rewritten
+				var errs []typeError
+				if errs, err = newErrors(); err == nil && len(errs) > 0 {
+					err = fmt.Errorf("%w: %s", ErrTypeCheck, errs[0])
+				}
+			}
+			if err != nil {
+				reasons[[2]int{e.Start, e.End}] = err
+				continue
+			}
+			kept = candidate
+		}
+		if result[i], err = fr.keep(kept, reasons); err != nil {
+			return err
+		}
+		contents[path] = result[i].Rewritten
+	}
+	return nil
+}
+
+// check type-checks the files of u, with contents in place of those on disk
+// for the paths it holds, and returns the syntax and type errors. The external
+// tests import the package as checked here rather than from disk, so that they
+// see its rewritten API.
+func (v *Verifier) check(u *checkUnit, contents map[string][]byte) ([]typeError, error) {
+	var errs []typeError
+	collect := func(err error) {
+		if te, ok := err.(types.Error); ok {
+			errs = append(errs, typeError{pos: te.Fset.Position(te.Pos), msg: te.Msg})
+		}
+	}
+	files, err := v.parse(u.files, contents, &errs)
+	if err != nil {
+		return nil, err
+	}
+	conf := types.Config{Importer: v.importer, FakeImportC: true, Error: collect}
+	// Errors are collected by conf.Error
+	pkg, _ := conf.Check(u.dir, v.fset, files, nil)
+	if len(u.xtests) == 0 {
+		return errs, nil
+	}
+
+	if files, err = v.parse(u.xtests, contents, &errs); err != nil {
+		return nil, err
+	}
+	conf.Importer = &xtestImporter{v: v, dir: filepath.Clean(u.dir), pkg: pkg}
+	_, _ = conf.Check(u.dir+"_test", v.fset, files, nil)
+	return errs, nil
+}
+
+// parse parses the files at paths, with contents in place of those on disk,
+// and appends their syntax errors to errs.
+func (v *Verifier) parse(paths []string, contents map[string][]byte, errs *[]typeError) ([]*ast.File, error) {
+	var files []*ast.File
+	for _, path := range paths {
+		src, ok := contents[path]
+		if !ok {
+			var err error
+			if src, err = os.ReadFile(path); err != nil {
+				return nil, err
+			}
+		}
+		f, err := parser.ParseFile(v.fset, path, src, parser.SkipObjectResolution)
+		var list scanner.ErrorList
+		if errors.As(err, &list) {
+			for _, e := range list {
+				*errs = append(*errs, typeError{pos: e.Pos, msg: e.Msg})
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// xtestImporter imports packages for the external tests of the package in
+// dir, answering imports of that package with pkg.
+type xtestImporter struct {
+	v   *Verifier
+	dir string
+	pkg *types.Package
+}
+
+func (im *xtestImporter) Import(path string) (*types.Package, error) {
+	return im.ImportFrom(path, "", 0)
+}
+
+func (im *xtestImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	key := [2]string{dir, path}
+	found, ok := im.v.dirs[key]
+	if !ok {
+		// Resolve module paths in the importing module rather than the
+		// working directory's
+		ctxt := build.Default
+		ctxt.Dir = dir
+		if p, err := ctxt.Import(path, dir, build.FindOnly); err == nil {
+			found = filepath.Clean(p.Dir)
+		}
+		im.v.dirs[key] = found
+	}
+	if found == im.dir {
+		return im.pkg, nil
+	}
+	return im.v.importer.ImportFrom(path, dir, mode)
+}
@@ -0,0 +1,172 @@
+package asq_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+func TestVerify(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-verify-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		"go.mod": "module example.com/v\n\ngo 1.23\n",
+		"defs.go": `package v
+
+func g(x any)  {}
+func h(x int)  {}
+func k(x bool) {}
+
+// A type error present before the rewrite
+var broken int = "x"
+`,
+		"a.go": `package v
+
+func f(a int, s string) {
+	g(a)
+	g(s)
+	g(a)
+}
+`,
+		"b.go": `package v
+
+func fb(b int) {
+	g(b)
+}
+`,
+		"c.go": `package v
+
+func fc(ok bool) {
+	g(ok)
+}
+`,
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	replacement := asq.NewReplacement("h(_asq_A)")
+	var rewrites []*asq.FileRewrite
+	for _, name := range []string{"a.go", "b.go", "c.go"} {
+		file := filepath.Join(tmpDir, name)
+		matches, err := asq.ValidateTreeSitterQuery(file, `(call_expression function: (identifier) @fn (#eq? @fn "g") arguments: (argument_list (identifier) @_asq_A)) @x`)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		fr, err := asq.RewriteFile(file, matches, replacement)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		rewrites = append(rewrites, fr)
+	}
+
+	verified, err := asq.NewVerifier().Verify(rewrites)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+		rejected int
+	}{
+		{
+			name:     "a.go",
+			expected: "package v\n\nfunc f(a int, s string) {\n\th(a)\n\tg(s)\n\th(a)\n}\n",
+			rejected: 1,
+		},
+		{
+			name:     "b.go",
+			expected: "package v\n\nfunc fb(b int) {\n\th(b)\n}\n",
+		},
+		{
+			name:     "c.go",
+			expected: "package v\n\nfunc fc(ok bool) {\n\tg(ok)\n}\n",
+			rejected: 1,
+		},
+	}
+	for i, tt := range tests {
+		fr := verified[i]
+		if got := string(fr.Rewritten); got != tt.expected {
+			t.Errorf("%s: Expected rewritten file:\n%s\ngot:\n%s", tt.name, tt.expected, got)
+		}
+		if len(fr.Skipped) != tt.rejected {
+			t.Fatalf("%s: Expected %d rejected matches, got %v", tt.name, tt.rejected, fr.Skipped)
+		}
+		for _, s := range fr.Skipped {
+			if !errors.Is(s.Reason, asq.ErrTypeCheck) || !strings.Contains(s.Reason.Error(), tt.name) {
+				t.Errorf("%s: Expected a type error in the file, got %v", tt.name, s.Reason)
+			}
+		}
+	}
+}
+
+func TestVerifyExternalTests(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-verify-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		"go.mod": "module example.com/w\n\ngo 1.23\n",
+		"w.go": `package w
+
+const n = 3
+
+var Limit = int64(n)
+
+func f() {
+	_ = int64(n)
+}
+`,
+		"w_test.go": `package w_test
+
+import "example.com/w"
+
+var _ int64 = w.Limit
+`,
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	// Both conversions compile within the package, but the first one changes
+	// the type of Limit, which the external test relies on
+	file := filepath.Join(tmpDir, "w.go")
+	matches, err := asq.ValidateTreeSitterQuery(file, `(call_expression function: (identifier) @fn (#eq? @fn "int64") arguments: (argument_list (identifier) @_asq_A)) @x`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fr, err := asq.RewriteFile(file, matches, asq.NewReplacement("int32(_asq_A)"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	verified, err := asq.NewVerifier().Verify([]*asq.FileRewrite{fr})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "package w\n\nconst n = 3\n\nvar Limit = int64(n)\n\nfunc f() {\n\t_ = int32(n)\n}\n"
+	if got := string(verified[0].Rewritten); got != expected {
+		t.Errorf("Expected rewritten file:\n%s\ngot:\n%s", expected, got)
+	}
+	if len(verified[0].Skipped) != 1 {
+		t.Fatalf("Expected 1 rejected match, got %v", verified[0].Skipped)
+	}
+	if reason := verified[0].Skipped[0].Reason; !errors.Is(reason, asq.ErrTypeCheck) || !strings.Contains(reason.Error(), "w_test.go") {
+		t.Errorf("Expected a type error in w_test.go, got %v", reason)
+	}
+}
@@ -0,0 +1,246 @@
+package asq
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// vcsDirs are the metadata directories of version control systems, which are
+// never walked.
+var vcsDirs = map[string]bool{".git": true, ".hg": true, ".svn": true, ".bzr": true, ".jj": true, "_darcs": true}
+
+// defaultSkipDirs are directories that are not walked unless
+// WalkOptions.NoIgnore is set: vendored and third-party code, and test
+// fixtures, which the go tool ignores as well.
+var defaultSkipDirs = map[string]bool{"vendor": true, "node_modules": true, "testdata": true}
+
+// TestFilter selects between test files, those whose name ends in _test.go,
+// and the other Go files.
+type TestFilter int
+
+const (
+	// TestsInclude visits test files and other files alike.
+	TestsInclude TestFilter = iota
+	// TestsExclude skips test files.
+	TestsExclude
+	// TestsOnly visits only test files.
+	TestsOnly
+)
+
+var testFilterNames = []string{"include", "exclude", "only"}
+
+func (f TestFilter) String() string {
+	if f < 0 || int(f) >= len(testFilterNames) {
+		return fmt.Sprintf("TestFilter(%d)", int(f))
+	}
+	return testFilterNames[f]
+}
+
+// ParseTestFilter returns the TestFilter named s: "include", "exclude" or
+// "only".
+func ParseTestFilter(s string) (TestFilter, error) {
+	for i, name := range testFilterNames {
+		if s == name {
+			return TestFilter(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown test filter %q (expected %s)", s, strings.Join(testFilterNames, ", "))
+}
+
+// WalkOptions controls which files WalkGoPaths visits.
+type WalkOptions struct {
+	// Include, if not empty, limits the walk to files matching one of these
+	// globs. Globs use gitignore syntax relative to the root being walked, so
+	// "*_test.go" matches at any depth and "cmd/**" only below cmd; a glob
+	// starting with "!" excludes what an earlier one included.
+	Include []string
+	// Exclude skips files and directories matching one of these globs.
+	Exclude []string
+	// NoIgnore disables .gitignore and .ignore files and the skipping of
+	// vendor, node_modules and testdata directories. Version control
+	// directories such as .git are skipped regardless.
+	NoIgnore bool
+	// FollowSymlinks visits linked files and descends into linked
+	// directories, except for links back to a directory that is already being
+	// walked. Without it symbolic links are skipped.
+	FollowSymlinks bool
+	// Generated visits generated files, which are skipped by default. A file
+	// is generated if a "// Code generated ... DO NOT EDIT." line precedes
+	// its package clause.
+	Generated bool
+	// Tests selects test files or the other files.
+	Tests TestFilter
+	// Build, if set, leaves out the files its GOOS, GOARCH and build tags
+	// exclude, through //go:build lines or file name suffixes such as
+	// _windows.go, as the go tool would.
+	Build *build.Context
+}
+
+// WalkGoFiles calls fn for every Go source file below root with the default
+// WalkOptions; see WalkGoPaths.
+func WalkGoFiles(root string, fn func(path string, info fs.FileInfo) error) error {
+	return WalkGoPaths([]string{root}, WalkOptions{}, fn)
+}
+
+// WalkGoPaths calls fn for every Go source file below roots, in lexical order
+// per root. It skips asq's own pattern files (those whose name starts with
+// "_asq_"), version control directories and whatever opts excludes. Ignore
+// files apply below the directory holding them, including those of the
+// directories above a root up to the root of its git repository. Roots that
+// are files are visited whatever their name, and are never filtered. fn may
+// return filepath.SkipAll to end the walk early.
+func WalkGoPaths(roots []string, opts WalkOptions, fn func(path string, info fs.FileInfo) error) error {
+	for _, root := range roots {
+		err := walkRoot(root, opts, fn)
+		if err == filepath.SkipAll {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walker holds the state of walking one root.
+type walker struct {
+	opts      WalkOptions
+	include   *ignoreRules
+	exclude   *ignoreRules
+	ancestors []fs.FileInfo // directories being walked, to detect symlink loops
+	fn        func(path string, info fs.FileInfo) error
+}
+
+func walkRoot(root string, opts WalkOptions, fn func(path string, info fs.FileInfo) error) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fn(root, info)
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	w := &walker{
+		opts:    opts,
+		include: newIgnoreRules(abs, opts.Include, nil),
+		exclude: newIgnoreRules(abs, opts.Exclude, nil),
+		fn:      fn,
+	}
+	var rules *ignoreRules
+	if !opts.NoIgnore {
+		if rules, err = repositoryIgnoreRules(abs); err != nil {
+			return err
+		}
+	}
+	return w.walkDir(root, abs, info, rules)
+}
+
+// walkDir walks the directory path, whose absolute path is abs, with the
+// ignore rules of the directories above it.
+func (w *walker) walkDir(path, abs string, info fs.FileInfo, rules *ignoreRules) error {
+	if w.opts.FollowSymlinks {
+		for _, ancestor := range w.ancestors {
+			if os.SameFile(ancestor, info) {
+				return nil // a link back up the tree
+			}
+		}
+		w.ancestors = append(w.ancestors, info)
+		defer func() { w.ancestors = w.ancestors[:len(w.ancestors)-1] }()
+	}
+	if !w.opts.NoIgnore {
+		var err error
+		if rules, err = loadIgnoreRules(abs, rules); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // removed while walking
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		childPath, childAbs := filepath.Join(path, name), filepath.Join(abs, name)
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // removed while walking
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if !w.opts.FollowSymlinks {
+				continue
+			}
+			if info, err = os.Stat(childPath); err != nil {
+				continue // a dangling link
+			}
+		}
+
+		if info.IsDir() {
+			if w.skipDir(name, childAbs, rules) {
+				continue
+			}
+			if err := w.walkDir(childPath, childAbs, info, rules); err != nil {
+				return err
+			}
+			continue
+		}
+		if filepath.Ext(name) != ".go" || strings.HasPrefix(name, "_asq_") || w.skipFile(path, name, childAbs, rules) {
+			continue
+		}
+		if err := w.fn(childPath, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *walker) skipDir(name, abs string, rules *ignoreRules) bool {
+	if vcsDirs[name] || w.exclude.ignored(abs, true) {
+		return true
+	}
+	return !w.opts.NoIgnore && (defaultSkipDirs[name] || rules.ignored(abs, true))
+}
+
+// skipFile reports whether the file name in dir should be skipped. Checks that
+// read the file come last.
+func (w *walker) skipFile(dir, name, abs string, rules *ignoreRules) bool {
+	if isTest := strings.HasSuffix(name, "_test.go"); isTest && w.opts.Tests == TestsExclude || !isTest && w.opts.Tests == TestsOnly {
+		return true
+	}
+	if w.exclude.ignored(abs, false) || rules.ignored(abs, false) {
+		return true
+	}
+	if len(w.opts.Include) > 0 && !w.include.ignored(abs, false) {
+		return true
+	}
+	if w.opts.Build != nil {
+		// Files that cannot be read are left for the search to report
+		if ok, err := w.opts.Build.MatchFile(dir, name); err == nil && !ok {
+			return true
+		}
+	}
+	return !w.opts.Generated && isGenerated(filepath.Join(dir, name))
+}
+
+// isGenerated reports whether the Go file at path is marked as generated, by
+// the rule of go/ast.IsGenerated.
+func isGenerated(path string) bool {
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.PackageClauseOnly|parser.ParseComments)
+	return err == nil && ast.IsGenerated(file)
+}
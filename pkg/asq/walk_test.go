@@ -0,0 +1,198 @@
+package asq_test
+
+import (
+	"go/build"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+func TestWalkGoPaths(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-walk-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		".git/HEAD":              "ref: refs/heads/main\n",
+		".git/hooks/h.go":        "package h\n",
+		".gitignore":             "/gen/\n*_gen.go\n# comment\n\\#odd.go\n",
+		"repo/a.go":              "package a\n",
+		"repo/a_test.go":         "package a\n",
+		"repo/#odd.go":           "package a\n",
+		"repo/_asq_pattern.go":   "package a\n",
+		"repo/.ignore":           "!keep_gen.go\nbuild/\n",
+		"repo/x_gen.go":          "package a\n",
+		"repo/keep_gen.go":       "package a\n",
+		"repo/build/b.go":        "package b\n",
+		"repo/sub/build":         "not a directory\n",
+		"repo/sub/s.go":          "package s\n",
+		"repo/sub/gen/g.go":      "package g\n",
+		"repo/vendor/v/v.go":     "package v\n",
+		"repo/testdata/t.go":     "package t\n",
+		"repo/node_modules/n.go": "package n\n",
+		"gen/top.go":             "package gen\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	// A link back to the repository root and a link to a file
+	if err := os.Symlink("..", filepath.Join(tmpDir, "repo/sub/loop")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.Symlink("sub/s.go", filepath.Join(tmpDir, "repo/link.go")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	repo := filepath.Join(tmpDir, "repo")
+	tests := []struct {
+		name     string
+		roots    []string
+		opts     asq.WalkOptions
+		expected []string
+	}{
+		{
+			name:     "defaults",
+			roots:    []string{repo},
+			expected: []string{"a.go", "a_test.go", "keep_gen.go", "sub/gen/g.go", "sub/s.go"},
+		},
+		{
+			name:     "no ignore",
+			roots:    []string{repo},
+			opts:     asq.WalkOptions{NoIgnore: true},
+			expected: []string{"#odd.go", "a.go", "a_test.go", "build/b.go", "keep_gen.go", "node_modules/n.go", "sub/gen/g.go", "sub/s.go", "testdata/t.go", "vendor/v/v.go", "x_gen.go"},
+		},
+		{
+			name:     "include and exclude",
+			roots:    []string{repo},
+			opts:     asq.WalkOptions{Include: []string{"*.go", "!*_test.go"}, Exclude: []string{"sub/gen"}},
+			expected: []string{"a.go", "keep_gen.go", "sub/s.go"},
+		},
+		{
+			name:     "follow symlinks",
+			roots:    []string{repo},
+			opts:     asq.WalkOptions{FollowSymlinks: true, Include: []string{"s.go", "link.go"}},
+			expected: []string{"link.go", "sub/s.go"},
+		},
+		{
+			name:     "explicit roots",
+			roots:    []string{filepath.Join(repo, "x_gen.go"), filepath.Join(repo, "vendor")},
+			expected: []string{"x_gen.go", "vendor/v/v.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := asq.WalkGoPaths(tt.roots, tt.opts, func(path string, info fs.FileInfo) error {
+				rel, _ := filepath.Rel(repo, path)
+				got = append(got, filepath.ToSlash(rel))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected files %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWalkGoPathsFilters(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-walk-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		"a.go":         "package a\n",
+		"a_test.go":    "package a\n",
+		"gen.go":       "// Code generated by stringer. DO NOT EDIT.\n\npackage a\n",
+		"late_gen.go":  "package a\n\n// Code generated by stringer. DO NOT EDIT.\n",
+		"tagged.go":    "//go:build special\n\npackage a\n",
+		"w_windows.go": "package a\n",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	linux := build.Default
+	linux.GOOS = "linux"
+	special := linux
+	special.BuildTags = []string{"special"}
+	tests := []struct {
+		name     string
+		opts     asq.WalkOptions
+		expected []string
+	}{
+		{
+			name:     "defaults",
+			expected: []string{"a.go", "a_test.go", "late_gen.go", "tagged.go", "w_windows.go"},
+		},
+		{
+			name:     "generated",
+			opts:     asq.WalkOptions{Generated: true},
+			expected: []string{"a.go", "a_test.go", "gen.go", "late_gen.go", "tagged.go", "w_windows.go"},
+		},
+		{
+			name:     "tests only",
+			opts:     asq.WalkOptions{Tests: asq.TestsOnly},
+			expected: []string{"a_test.go"},
+		},
+		{
+			name:     "tests excluded",
+			opts:     asq.WalkOptions{Tests: asq.TestsExclude},
+			expected: []string{"a.go", "late_gen.go", "tagged.go", "w_windows.go"},
+		},
+		{
+			name:     "build constraints",
+			opts:     asq.WalkOptions{Build: &linux},
+			expected: []string{"a.go", "a_test.go", "late_gen.go"},
+		},
+		{
+			name:     "build tags",
+			opts:     asq.WalkOptions{Build: &special},
+			expected: []string{"a.go", "a_test.go", "late_gen.go", "tagged.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := asq.WalkGoPaths([]string{tmpDir}, tt.opts, func(path string, info fs.FileInfo) error {
+				got = append(got, filepath.Base(path))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected files %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	for _, s := range []string{"include", "exclude", "only"} {
+		if f, err := asq.ParseTestFilter(s); err != nil || f.String() != s {
+			t.Errorf("Expected ParseTestFilter(%q) to round-trip, got %v (%v)", s, f, err)
+		}
+	}
+	if _, err := asq.ParseTestFilter("some"); err == nil {
+		t.Errorf("Expected an error for an unknown test filter")
+	}
+}
@@ -0,0 +1,230 @@
+package asq
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// Workspace keeps the parsed tree-sitter tree of every Go file below Root in
+// memory, so that queries can be answered without re-reading or re-parsing the
+// tree. Changed files are re-parsed incrementally from their previous tree.
+type Workspace struct {
+	Root string
+	// Walk selects the files below Root, as it does for SearchPaths.
+	Walk WalkOptions
+
+	mu     sync.Mutex
+	parser *sitter.Parser
+	files  map[string]*workspaceFile
+}
+
+type workspaceFile struct {
+	contents []byte
+	lang     *sitter.Language
+	tree     *sitter.Tree
+	size     int64
+	modTime  time.Time
+}
+
+// WorkspaceFileMatches holds the matches of a query within one workspace file.
+type WorkspaceFileMatches struct {
+	Path    string
+	Matches []Match
+}
+
+// NewWorkspace parses every Go file below root with the default WalkOptions;
+// see NewWorkspaceOptions.
+func NewWorkspace(root string) (*Workspace, error) {
+	return NewWorkspaceOptions(root, WalkOptions{})
+}
+
+// NewWorkspaceOptions parses every Go file below root that opts selects.
+func NewWorkspaceOptions(root string, opts WalkOptions) (*Workspace, error) {
+	w := &Workspace{
+		Root:   root,
+		Walk:   opts,
+		parser: sitter.NewParser(),
+		files:  make(map[string]*workspaceFile),
+	}
+	if _, err := w.Sync(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Len returns the number of files held in memory.
+func (w *Workspace) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.files)
+}
+
+// Sync brings the in-memory trees up to date with the file system. New files
+// are parsed, deleted files and files Walk no longer selects are dropped, and
+// files whose size or modification time changed are re-parsed incrementally.
+// It returns the number of files that were added, updated or removed.
+func (w *Workspace) Sync() (int, error) {
+	seen := make(map[string]bool)
+	changed := 0
+	err := WalkGoPaths([]string{w.Root}, w.Walk, func(path string, info fs.FileInfo) error {
+		seen[path] = true
+
+		w.mu.Lock()
+		file := w.files[path]
+		w.mu.Unlock()
+		if file != nil && file.size == info.Size() && file.modTime.Equal(info.ModTime()) {
+			return nil
+		}
+
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		if file != nil && bytes.Equal(file.contents, contents) {
+			w.mu.Lock()
+			file.size, file.modTime = info.Size(), info.ModTime()
+			w.mu.Unlock()
+			return nil
+		}
+		if err := w.update(path, contents, info); err == nil {
+			changed++
+		}
+		return nil
+	})
+	if err != nil {
+		return changed, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for path, file := range w.files {
+		if !seen[path] {
+			file.tree.Close()
+			delete(w.files, path)
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// Watch polls the file system every interval and calls Sync until ctx is done.
+// Sync errors are passed to onError, which may be nil.
+func (w *Workspace) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := w.Sync(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// update replaces the contents of path, reusing the previous tree when there
+// is one so that tree-sitter only re-parses the edited region.
+func (w *Workspace) update(path string, contents []byte, info os.FileInfo) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	file := w.files[path]
+	var oldTree *sitter.Tree
+	var lang *sitter.Language
+	if file != nil {
+		oldTree = file.tree
+		lang = file.lang
+		oldTree.Edit(computeEdit(file.contents, contents))
+	} else {
+		var err error
+		lang, err = GetTSLanguageFromEnry(path, contents)
+		if err != nil {
+			return fmt.Errorf("failed to get language: %w", err)
+		}
+	}
+
+	w.parser.SetLanguage(lang)
+	tree := w.parser.Parse(oldTree, contents)
+	if tree == nil {
+		return fmt.Errorf("%w: %s", ErrParse, path)
+	}
+	if oldTree != nil {
+		oldTree.Close()
+	}
+	w.files[path] = &workspaceFile{
+		contents: contents,
+		lang:     lang,
+		tree:     tree,
+		size:     info.Size(),
+		modTime:  info.ModTime(),
+	}
+	return nil
+}
+
+// Query runs a tree-sitter query over every file in the workspace and returns
+// the files with matches, sorted by path. It returns ctx.Err() if ctx is done
+// before every file has been searched.
+func (w *Workspace) Query(ctx context.Context, query string) ([]WorkspaceFileMatches, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	queries := newQueryCache(query)
+	defer queries.close()
+
+	var results []WorkspaceFileMatches
+	for path, file := range w.files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		q, err := queries.get(file.lang)
+		if err != nil {
+			return nil, err
+		}
+		var matches []Match
+		for match := range queryMatches(q, file.tree.RootNode(), file.contents, nil) {
+			match.File = path
+			matches = append(matches, match)
+		}
+		if len(matches) > 0 {
+			results = append(results, WorkspaceFileMatches{Path: path, Matches: matches})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Path < results[j].Path
+	})
+	return results, nil
+}
+
+// computeEdit describes the change from oldContents to newContents as a single
+// edit spanning everything between their common prefix and common suffix.
+func computeEdit(oldContents, newContents []byte) sitter.EditInput {
+	prefix := 0
+	for prefix < len(oldContents) && prefix < len(newContents) && oldContents[prefix] == newContents[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldContents)-prefix && suffix < len(newContents)-prefix &&
+		oldContents[len(oldContents)-1-suffix] == newContents[len(newContents)-1-suffix] {
+		suffix++
+	}
+	oldEnd := len(oldContents) - suffix
+	newEnd := len(newContents) - suffix
+	return sitter.EditInput{
+		StartIndex:  uint32(prefix),
+		OldEndIndex: uint32(oldEnd),
+		NewEndIndex: uint32(newEnd),
+		StartPoint:  pointAt(oldContents, prefix),
+		OldEndPoint: pointAt(oldContents, oldEnd),
+		NewEndPoint: pointAt(newContents, newEnd),
+	}
+}
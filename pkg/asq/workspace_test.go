@@ -0,0 +1,130 @@
+package asq_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+func TestWorkspaceIncrementalSync(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-workspace-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, "a.go")
+	src := "package a\n\nfunc f() {\n\tg()\n}\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	ws, err := asq.NewWorkspace(tmpDir)
+	if err != nil {
+		t.Fatalf("NewWorkspace failed: %v", err)
+	}
+	query := `(call_expression) @x`
+
+	results, err := ws.Query(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Matches) != 1 || results[0].Matches[0].Row != 4 {
+		t.Fatalf("Expected one match on line 4, got %+v", results)
+	}
+
+	// Insert lines above the call and add a second one below it
+	src = "package a\n\n// f calls g.\n// Twice.\nfunc f() {\n\tg()\n\th(1)\n}\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if changed, err := ws.Sync(); err != nil || changed != 1 {
+		t.Fatalf("Expected 1 changed file, got %d (%v)", changed, err)
+	}
+
+	results, err = ws.Query(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Matches) != 2 {
+		t.Fatalf("Expected two matches, got %+v", results)
+	}
+	for i, expected := range []struct {
+		row  int
+		code string
+	}{{6, "g()"}, {7, "h(1)"}} {
+		if got := results[0].Matches[i]; got.Row != expected.row || got.Code != expected.code {
+			t.Errorf("Match %d: expected %d %q, got %d %q", i, expected.row, expected.code, got.Row, got.Code)
+		}
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatalf("Failed to remove test file: %v", err)
+	}
+	if _, err := ws.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if ws.Len() != 0 {
+		t.Errorf("Expected removed file to be dropped, have %d files", ws.Len())
+	}
+}
+
+func TestWorkspaceWalkOptions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-workspace-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		".gitignore":      "ignored.go\n",
+		"a.go":            "package a\n\nfunc f() { g() }\n",
+		"a_test.go":       "package a\n\nfunc t() { g() }\n",
+		"ignored.go":      "package a\n\nfunc i() { g() }\n",
+		"gen.go":          "// Code generated by hand. DO NOT EDIT.\n\npackage a\n\nfunc h() { g() }\n",
+		"vendor/v/v.go":   "package v\n\nfunc v() { g() }\n",
+		"testdata/t.go":   "package t\n\nfunc t() { g() }\n",
+		"_asq_pattern.go": "package a\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	ws, err := asq.NewWorkspace(tmpDir)
+	if err != nil {
+		t.Fatalf("NewWorkspace failed: %v", err)
+	}
+	if ws.Len() != 2 {
+		t.Errorf("Expected the workspace to hold a.go and a_test.go, got %d files", ws.Len())
+	}
+
+	// Files the options no longer select are dropped
+	ws.Walk = asq.WalkOptions{Tests: asq.TestsExclude}
+	if changed, err := ws.Sync(); err != nil || changed != 1 {
+		t.Fatalf("Expected 1 changed file, got %d (%v)", changed, err)
+	}
+	results, err := ws.Query(context.Background(), `(call_expression) @x`)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Path != filepath.Join(tmpDir, "a.go") {
+		t.Errorf("Expected matches only in a.go, got %+v", results)
+	}
+
+	ws, err = asq.NewWorkspaceOptions(tmpDir, asq.WalkOptions{NoIgnore: true, Generated: true})
+	if err != nil {
+		t.Fatalf("NewWorkspaceOptions failed: %v", err)
+	}
+	if ws.Len() != 6 {
+		t.Errorf("Expected the workspace to hold every Go file but the pattern, got %d files", ws.Len())
+	}
+}
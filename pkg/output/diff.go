@@ -0,0 +1,236 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+const (
+	colorDiffHeader = "\x1b[1m"
+	colorDiffHunk   = "\x1b[36m"
+	colorDiffDelete = "\x1b[31m"
+	colorDiffInsert = "\x1b[32m"
+)
+
+// lineOp is a line of a diff: kept (' '), deleted ('-') or inserted ('+').
+// The line includes its newline, if it has one.
+type lineOp struct {
+	kind byte
+	line string
+}
+
+// WriteDiff writes the changes from old to new contents of the file at path
+// as a unified diff with git headers, which git apply accepts. It writes
+// nothing if the contents are equal. With color, lines are coloured like git
+// diff colours them.
+func WriteDiff(w io.Writer, path string, old, new []byte, color bool) error {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+	path = filepath.ToSlash(filepath.Clean(path))
+	bw := bufio.NewWriter(w)
+	paint := func(code, s string) {
+		if color {
+			bw.WriteString(code + s + colorReset)
+		} else {
+			bw.WriteString(s)
+		}
+	}
+
+	paint(colorDiffHeader, fmt.Sprintf("diff --git a/%s b/%s", path, path))
+	bw.WriteString("\n")
+	paint(colorDiffHeader, "--- a/"+path)
+	bw.WriteString("\n")
+	paint(colorDiffHeader, "+++ b/"+path)
+	bw.WriteString("\n")
+
+	ops := diffLines(splitLines(old), splitLines(new))
+	// oldLines[i] and newLines[i] count the lines of each side before ops[i]
+	oldLines, newLines := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldLines[i+1], newLines[i+1] = oldLines[i], newLines[i]
+		if op.kind != '+' {
+			oldLines[i+1]++
+		}
+		if op.kind != '-' {
+			newLines[i+1]++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		// Extend the hunk over changes separated by at most twice the context
+		end := i
+		for j := i; j < len(ops); {
+			if ops[j].kind != ' ' {
+				j++
+				end = j
+				continue
+			}
+			k := j
+			for k < len(ops) && ops[k].kind == ' ' {
+				k++
+			}
+			if k == len(ops) || k-j > 2*diffContext {
+				break
+			}
+			j = k
+		}
+		start, stop := max(0, i-diffContext), min(len(ops), end+diffContext)
+
+		paint(colorDiffHunk, fmt.Sprintf("@@ -%s +%s @@",
+			hunkRange(oldLines[start], oldLines[stop]-oldLines[start]),
+			hunkRange(newLines[start], newLines[stop]-newLines[start])))
+		bw.WriteString("\n")
+		for _, op := range ops[start:stop] {
+			line, newline := op.line, true
+			if line[len(line)-1] == '\n' {
+				line = line[:len(line)-1]
+			} else {
+				newline = false
+			}
+			switch op.kind {
+			case '-':
+				paint(colorDiffDelete, "-"+line)
+			case '+':
+				paint(colorDiffInsert, "+"+line)
+			default:
+				bw.WriteString(" " + line)
+			}
+			bw.WriteString("\n")
+			if !newline {
+				bw.WriteString("\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+	return bw.Flush()
+}
+
+// hunkRange formats the lines of one side of a hunk that starts after the
+// first before lines: the first line and, unless it is 1, the count. An
+// empty range is given by the line before it.
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", before)
+	case 1:
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+// splitLines splits contents into lines that keep their newline.
+func splitLines(contents []byte) []string {
+	var lines []string
+	for len(contents) > 0 {
+		n := bytes.IndexByte(contents, '\n') + 1
+		if n == 0 {
+			n = len(contents)
+		}
+		lines = append(lines, string(contents[:n]))
+		contents = contents[n:]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script turning a into b.
+func diffLines(a, b []string) []lineOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []lineOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, lineOp{' ', line})
+	}
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, lineOp{' ', line})
+	}
+	return ops
+}
+
+// myers computes the shortest edit script turning a into b with Myers'
+// O(ND) algorithm.
+func myers(a, b []string) []lineOp {
+	n, m := len(a), len(b)
+	offset := n + m
+	v := make([]int, 2*offset+2)
+	// trace[d] is v before step d, from which the path is recovered
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, slices.Clone(v))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, offset)
+			}
+		}
+	}
+	return nil // not reached
+}
+
+// backtrack follows the edit script recorded in trace back from the ends of
+// a and b.
+func backtrack(trace [][]int, a, b []string, offset int) []lineOp {
+	var ops []lineOp
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, lineOp{' ', a[x]})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, lineOp{'+', b[y]})
+		} else {
+			x--
+			ops = append(ops, lineOp{'-', a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, lineOp{' ', a[x]})
+	}
+	slices.Reverse(ops)
+	return ops
+}
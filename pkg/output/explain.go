@@ -0,0 +1,103 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"go/token"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+// explainTextWidth is the number of characters of code shown for a node.
+const explainTextWidth = 40
+
+// WriteExplanation writes e as text in four sections: the lines of the
+// pattern region, the node tree, the /***/ wildcards, and the indented query
+// with each line annotated, as a query comment, with the node it comes from.
+func WriteExplanation(w io.Writer, e *asq.Explanation) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "pattern %s\n", e.File)
+
+	fmt.Fprintf(bw, "\nregion:\n")
+	width := 1
+	if len(e.Region) > 0 {
+		width = len(strconv.Itoa(e.Region[len(e.Region)-1].Line))
+	}
+	for _, line := range e.Region {
+		fmt.Fprintf(bw, "  %*d | %s\n", width, line.Line, line.Text)
+	}
+
+	fmt.Fprintf(bw, "\nnodes:\n")
+	writeExplainNode(bw, e.Root, 1)
+
+	fmt.Fprintf(bw, "\nwildcards:\n")
+	if len(e.Intervals) == 0 {
+		fmt.Fprintf(bw, "  no /***/ comments\n")
+	}
+	for _, interval := range e.Intervals {
+		fmt.Fprintf(bw, "  /***/ at %s, to %s: ", explainPos(interval.Pos), explainPos(interval.End))
+		if interval.Ident != nil {
+			fmt.Fprintf(bw, "makes %s at %s a wildcard\n", interval.Ident.Text, explainPos(interval.Ident.Pos))
+		} else {
+			fmt.Fprintf(bw, "dropped: %s\n", interval.Reason)
+		}
+	}
+
+	fmt.Fprintf(bw, "\nquery:\n")
+	if e.Err != nil {
+		fmt.Fprintf(bw, "  error: %v\n", e.Err)
+		return bw.Flush()
+	}
+	width = 0
+	for _, line := range e.QueryLines {
+		width = max(width, len(line.Text))
+	}
+	for _, line := range e.QueryLines {
+		if line.Node == nil || !line.Node.Pos.IsValid() {
+			fmt.Fprintf(bw, "  %s\n", line.Text)
+			continue
+		}
+		fmt.Fprintf(bw, "  %-*s  ; %s %s\n", width, line.Text, explainPos(line.Node.Pos), explainText(line.Node.Text))
+	}
+	return bw.Flush()
+}
+
+func writeExplainNode(w io.Writer, n *asq.ExplainNode, depth int) {
+	if n == nil {
+		return
+	}
+	fmt.Fprintf(w, "%s%s", strings.Repeat("  ", depth), n.Kind)
+	if n.Pos.IsValid() {
+		fmt.Fprintf(w, " %s-%s %s", explainPos(n.Pos), explainPos(n.End), explainText(n.Text))
+	}
+	switch {
+	case n.Metavariable:
+		fmt.Fprintf(w, " [metavariable @%s]", n.Text)
+	case n.Wildcard:
+		fmt.Fprintf(w, " [wildcard]")
+	}
+	fmt.Fprintln(w)
+	for _, child := range n.Children {
+		writeExplainNode(w, child, depth+1)
+	}
+}
+
+// explainPos formats a position within the pattern file as line:column.
+func explainPos(pos token.Position) string {
+	if !pos.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+// explainText puts code on one line, shortened to explainTextWidth.
+func explainText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > explainTextWidth {
+		text = string(runes[:explainTextWidth-3]) + "..."
+	}
+	return text
+}
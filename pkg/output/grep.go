@@ -0,0 +1,118 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+// tabWidth is the tab stop assumed for screen columns, as in the GNU
+// error message conventions.
+const tabWidth = 8
+
+// lineWriter prints one line per match: the file, the line and column where
+// the match starts and the text of that source line, for editors to jump to.
+// The vimgrep format uses 1-based byte columns like grep and ripgrep, which is
+// what Vim's quickfix list expects. The emacs format follows the GNU
+// convention of 1-based screen columns with tab stops every eight columns,
+// which is how compilation-mode and M-x grep count them. Either gives way to
+// an explicit column encoding.
+type lineWriter struct {
+	w      io.Writer
+	source source
+	// separator goes between the column and the text
+	separator string
+	// screen selects screen columns over byte columns
+	screen bool
+	// columns, if set, is the encoding of columns instead
+	columns asq.ColumnEncoding
+}
+
+func (l *lineWriter) WriteMatch(m asq.Match) error {
+	l.source.load(m.File)
+	line, prefix := l.source.line(m.StartByte, m.Col)
+	col := m.Col + 1
+	if line == nil {
+		// The file could not be read again; use the first line of the match
+		line = []byte(strings.SplitN(m.Text, "\n", 2)[0])
+	} else if l.columns != 0 {
+		col = l.columns.Count(prefix) + 1
+	} else if l.screen {
+		col = screenColumn(prefix)
+	}
+	_, err := fmt.Fprintf(l.w, "%s:%d:%d:%s%s\n", m.File, m.Row, col, l.separator, line)
+	return err
+}
+
+func (l *lineWriter) Close() error {
+	return nil
+}
+
+// screenColumn returns the 1-based screen column following prefix.
+func screenColumn(prefix []byte) int {
+	col := 0
+	for len(prefix) > 0 {
+		r, size := utf8.DecodeRune(prefix)
+		prefix = prefix[size:]
+		if r == '\t' {
+			col += tabWidth - col%tabWidth
+		} else {
+			col++
+		}
+	}
+	return col + 1
+}
+
+// filesWriter prints the name of each file with at least one match.
+type filesWriter struct {
+	w    io.Writer
+	last string
+}
+
+func (f *filesWriter) WriteMatch(m asq.Match) error {
+	if m.File == f.last {
+		return nil
+	}
+	f.last = m.File
+	_, err := fmt.Fprintln(f.w, m.File)
+	return err
+}
+
+func (f *filesWriter) Close() error {
+	return nil
+}
+
+// countWriter prints the number of matches of each file with at least one
+// match, as path:count.
+type countWriter struct {
+	w     io.Writer
+	file  string
+	count int
+}
+
+func (c *countWriter) WriteMatch(m asq.Match) error {
+	if m.File != c.file {
+		if err := c.flush(); err != nil {
+			return err
+		}
+		c.file = m.File
+	}
+	c.count++
+	return nil
+}
+
+func (c *countWriter) Close() error {
+	return c.flush()
+}
+
+func (c *countWriter) flush() error {
+	if c.count == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(c.w, "%s:%d\n", c.file, c.count)
+	c.count = 0
+	return err
+}
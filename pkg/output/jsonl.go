@@ -0,0 +1,90 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+// JSONPosition is a position in a file. Lines are 1-based; columns are 0-based
+// and count bytes unless another encoding was asked for. Byte is the 0-based
+// offset in the file.
+type JSONPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Byte   int `json:"byte"`
+}
+
+// JSONCapture is the JSON form of an asq.Capture.
+type JSONCapture struct {
+	Name  string       `json:"name"`
+	Start JSONPosition `json:"start"`
+	End   JSONPosition `json:"end"`
+	Text  string       `json:"text"`
+}
+
+// JSONMatch is the JSON form of an asq.Match, as written by the jsonl format.
+type JSONMatch struct {
+	File     string        `json:"file"`
+	Pattern  string        `json:"pattern,omitempty"`
+	Function string        `json:"function,omitempty"`
+	Start    JSONPosition  `json:"start"`
+	End      JSONPosition  `json:"end"`
+	Text     string        `json:"text"`
+	Captures []JSONCapture `json:"captures"`
+}
+
+// NewJSONMatch converts a match to its JSON form, with byte columns.
+func NewJSONMatch(m asq.Match) JSONMatch {
+	return NewJSONMatchColumns(m, asq.ColumnBytes)
+}
+
+// NewJSONMatchColumns converts a match to its JSON form, with columns counted
+// in enc, which are counted in the file as it is now.
+func NewJSONMatchColumns(m asq.Match, enc asq.ColumnEncoding) JSONMatch {
+	var s source
+	return s.jsonMatch(m, enc)
+}
+
+func (s *source) jsonMatch(m asq.Match, enc asq.ColumnEncoding) JSONMatch {
+	jm := JSONMatch{
+		File:     m.File,
+		Pattern:  m.Pattern,
+		Function: m.Function,
+		Start:    JSONPosition{Line: m.Row, Column: s.column(m, m.StartByte, m.Col, enc), Byte: m.StartByte},
+		End:      JSONPosition{Line: m.EndRow, Column: s.column(m, m.EndByte, m.EndCol, enc), Byte: m.EndByte},
+		Text:     m.Text,
+		Captures: make([]JSONCapture, 0, len(m.Captures)),
+	}
+	for _, c := range m.Captures {
+		jm.Captures = append(jm.Captures, JSONCapture{
+			Name:  c.Name,
+			Start: JSONPosition{Line: c.Row, Column: s.column(m, c.StartByte, c.Col, enc), Byte: c.StartByte},
+			End:   JSONPosition{Line: c.EndRow, Column: s.column(m, c.EndByte, c.EndCol, enc), Byte: c.EndByte},
+			Text:  c.Text,
+		})
+	}
+	return jm
+}
+
+// jsonlWriter writes one JSON object per match and line.
+type jsonlWriter struct {
+	enc     *json.Encoder
+	source  source
+	columns asq.ColumnEncoding
+}
+
+func newJSONLWriter(w io.Writer, columns asq.ColumnEncoding) *jsonlWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonlWriter{enc: enc, columns: columns}
+}
+
+func (j *jsonlWriter) WriteMatch(m asq.Match) error {
+	return j.enc.Encode(j.source.jsonMatch(m, j.columns))
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
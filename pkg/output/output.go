@@ -0,0 +1,227 @@
+// Package output renders asq matches in the formats supported by the asq
+// command line tool.
+package output
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+// Writer renders a stream of matches. Matches of the same file arrive
+// consecutively. Close must be called after the last match so that buffered
+// output and any footer are written.
+type Writer interface {
+	WriteMatch(m asq.Match) error
+	Close() error
+}
+
+// Options selects and configures an output format.
+type Options struct {
+	// Format is one of the names returned by Formats. The empty string
+	// selects "text".
+	Format string
+	// Patterns describes the patterns being searched for. Formats that
+	// report rules, such as sarif, list every pattern even if it did not
+	// match; matches of unlisted patterns get default descriptions.
+	Patterns []asq.PatternInfo
+	// FilesWithMatches prints only the name of each file with a match,
+	// regardless of Format.
+	FilesWithMatches bool
+	// Count prints only the number of matches of each file with a match,
+	// regardless of Format.
+	Count bool
+	// Before and After are the number of context lines the text format
+	// prints before and after each match.
+	Before, After int
+	// Color highlights matches, metavariable captures, file names and line
+	// numbers in the text format with ANSI escape sequences.
+	Color bool
+	// Boundary selects the enclosing code shown around matches by the cursor
+	// format and by the Group of a template match.
+	Boundary asq.Boundary
+	// Definitions adds to the cursor format the declarations that matched
+	// code depends on, as found by asq.DefinitionGroups, each only once.
+	Definitions bool
+	// MaxTokens limits the cursor format to about this many tokens, as
+	// estimated by EstimateTokens. Zero means no limit.
+	MaxTokens int
+	// Columns is the unit of the columns of positions, in the formats that
+	// print them. The zero value keeps each format's convention: bytes, but
+	// screen columns for emacs and UTF-16 code units for sarif, which cannot
+	// count bytes.
+	Columns asq.ColumnEncoding
+	// Template is text/template source executed for each match with a
+	// TemplateMatch, regardless of Format. Header and Footer are executed
+	// with a TemplateReport before the first and after the last match.
+	Template, Header, Footer string
+}
+
+// GroupsMatches reports whether the writer for opts groups matches by their
+// enclosing code, so that a search feeding it should record that code with
+// asq.SearchOptions.Scopes. Templates are assumed to group matches when they
+// mention Group.
+func (opts Options) GroupsMatches() bool {
+	switch {
+	case opts.FilesWithMatches || opts.Count:
+		return false
+	case opts.Template != "":
+		return strings.Contains(opts.Template, "Group")
+	}
+	return opts.Format == "cursor"
+}
+
+var formats = map[string]func(w io.Writer, opts Options) (Writer, error){
+	"text": func(w io.Writer, opts Options) (Writer, error) {
+		return &textWriter{w: w, opts: opts}, nil
+	},
+	"cursor": func(w io.Writer, opts Options) (Writer, error) {
+		return &cursorWriter{w: w, boundary: opts.Boundary, maxTokens: opts.MaxTokens, definitions: opts.Definitions}, nil
+	},
+	"jsonl": func(w io.Writer, opts Options) (Writer, error) {
+		return newJSONLWriter(w, opts.Columns), nil
+	},
+	"vimgrep": func(w io.Writer, opts Options) (Writer, error) {
+		return &lineWriter{w: w, columns: opts.Columns}, nil
+	},
+	"emacs": func(w io.Writer, opts Options) (Writer, error) {
+		return &lineWriter{w: w, separator: " ", screen: true, columns: opts.Columns}, nil
+	},
+	"sarif": func(w io.Writer, opts Options) (Writer, error) {
+		return newSARIFWriter(w, opts)
+	},
+}
+
+// Formats returns the names of the supported output formats.
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns a Writer for the format selected by opts.
+func New(w io.Writer, opts Options) (Writer, error) {
+	switch {
+	case opts.FilesWithMatches && opts.Count:
+		return nil, fmt.Errorf("files-with-matches and count cannot be combined")
+	case opts.FilesWithMatches:
+		return &filesWriter{w: w}, nil
+	case opts.Count:
+		return &countWriter{w: w}, nil
+	case opts.Template != "":
+		return newTemplateWriter(w, opts)
+	case opts.Header != "" || opts.Footer != "":
+		return nil, fmt.Errorf("header and footer templates require a template")
+	}
+
+	format := opts.Format
+	if format == "" {
+		format = "text"
+	}
+	if opts.MaxTokens > 0 && format != "cursor" {
+		return nil, fmt.Errorf("a token limit requires the cursor format")
+	}
+	newWriter, ok := formats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q (expected one of %s)", format, strings.Join(Formats(), ", "))
+	}
+	return newWriter(w, opts)
+}
+
+// cursorWriter prints the matches of each file grouped by their enclosing
+// function, or other code selected by Options.Boundary, in <especially_relevant_code_snippet> blocks. The matches of a file
+// are held back until the next file starts. With a token budget, all groups
+// are held back until Close and packed into the budget.
+type cursorWriter struct {
+	w           io.Writer
+	boundary    asq.Boundary
+	maxTokens   int
+	definitions bool
+	pending     []asq.Match
+	packed      []packedGroup
+	seen        map[string]bool // definitions already written, by file and line
+}
+
+func (c *cursorWriter) WriteMatch(m asq.Match) error {
+	if len(c.pending) > 0 && c.pending[0].File != m.File {
+		if err := c.flush(); err != nil {
+			return err
+		}
+	}
+	c.pending = append(c.pending, m)
+	return nil
+}
+
+func (c *cursorWriter) Close() error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+	if c.maxTokens > 0 {
+		return c.pack()
+	}
+	return nil
+}
+
+func (c *cursorWriter) flush() error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+	path, matches := c.pending[0].File, c.pending
+	c.pending = nil
+
+	// Group matches for deduplication
+	groups, err := asq.GroupMatches(path, matches, c.boundary)
+	if err != nil {
+		return fmt.Errorf("grouping matches in %s: %w", path, err)
+	}
+
+	// Output each group
+	for _, group := range groups {
+		header := group.FilePath
+		if !group.IsFunction && len(matches) == 1 {
+			// Show line number only for single root-level matches
+			header = fmt.Sprintf("%s:%d", group.FilePath, group.StartLine)
+		}
+		if err := c.writeGroup(group, header); err != nil {
+			return err
+		}
+	}
+
+	if !c.definitions {
+		return nil
+	}
+	definitions, err := asq.DefinitionGroups(path, matches)
+	if err != nil {
+		return fmt.Errorf("finding definitions in %s: %w", path, err)
+	}
+	if c.seen == nil {
+		c.seen = make(map[string]bool)
+	}
+	for _, group := range definitions {
+		header := fmt.Sprintf("%s:%d", group.FilePath, group.StartLine)
+		if c.seen[header] {
+			continue
+		}
+		c.seen[header] = true
+		if err := c.writeGroup(group, header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeGroup writes a group, or holds it back for packing.
+func (c *cursorWriter) writeGroup(group asq.MatchGroup, header string) error {
+	if c.maxTokens > 0 {
+		c.packed = append(c.packed, packedGroup{group: group, header: header, order: len(c.packed)})
+		return nil
+	}
+	_, err := fmt.Fprint(c.w, cursorBlock(header, group.Snippet))
+	return err
+}
@@ -0,0 +1,654 @@
+package output_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+	"github.com/StCredZero/asq/pkg/output"
+)
+
+var testMatches = []asq.Match{
+	{
+		File: "a.go", Row: 4, Col: 1, EndRow: 4, EndCol: 4, StartByte: 30, EndByte: 33,
+		Code: "g()", Text: "g()", Function: "f", Pattern: "calls",
+		Captures: []asq.Capture{
+			{Name: "_asq_F", Row: 4, Col: 1, EndRow: 4, EndCol: 2, StartByte: 30, EndByte: 31, Text: "g"},
+		},
+	},
+	{
+		File: "b/b.go", Row: 9, Col: 8, EndRow: 9, EndCol: 14, StartByte: 90, EndByte: 96,
+		Code: "h(<a>)", Text: "h(<a>)", Pattern: "calls",
+	},
+}
+
+func render(t *testing.T, opts output.Options, matches []asq.Match) string {
+	var buf bytes.Buffer
+	w, err := output.New(&buf, opts)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	for _, m := range matches {
+		if err := w.WriteMatch(m); err != nil {
+			t.Fatalf("WriteMatch failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestText(t *testing.T) {
+	expected := "//asq_match a.go:4:1\ng()\n//asq_match b/b.go:9:8\nh(<a>)\n"
+	if got := render(t, output.Options{}, testMatches); got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	got := render(t, output.Options{Format: "jsonl"}, testMatches)
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d:\n%s", len(lines), got)
+	}
+
+	expected := `{"file":"a.go","pattern":"calls","function":"f","start":{"line":4,"column":1,"byte":30},"end":{"line":4,"column":4,"byte":33},"text":"g()","captures":[{"name":"_asq_F","start":{"line":4,"column":1,"byte":30},"end":{"line":4,"column":2,"byte":31},"text":"g"}]}`
+	if lines[0] != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, lines[0])
+	}
+
+	var m output.JSONMatch
+	if err := json.Unmarshal([]byte(lines[1]), &m); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if m.Text != "h(<a>)" || m.Captures == nil || len(m.Captures) != 0 {
+		t.Errorf("Unexpected match %+v", m)
+	}
+}
+
+func TestJSONLCaptures(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-output-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pattern := filepath.Join(tmpDir, "pattern.go")
+	if err := os.WriteFile(pattern, []byte("package p\n\nfunc p() {\n\t//asq_start\n\t_asq_X.Inst().Foo()\n\t//asq_end\n}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write pattern file: %v", err)
+	}
+	file := filepath.Join(tmpDir, "a.go")
+	if err := os.WriteFile(file, []byte("package a\n\nfunc f() {\n\te.Inst().Foo()\n}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	query, err := asq.ExtractTreeSitterQuery(pattern)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var matches []asq.Match
+	for m, err := range asq.MatchFile(context.Background(), file, query) {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		matches = append(matches, m)
+	}
+
+	// Only the metavariables are listed, not the captures of the query's
+	// own predicates or of the whole match
+	var m output.JSONMatch
+	if err := json.Unmarshal([]byte(render(t, output.Options{Format: "jsonl"}, matches)), &m); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	got, err := json.Marshal(m.Captures)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `[{"name":"_asq_X","start":{"line":4,"column":1,"byte":23},"end":{"line":4,"column":2,"byte":24},"text":"e"}]`
+	if string(got) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := output.New(&bytes.Buffer{}, output.Options{Format: "xml"}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestLineFormats(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-output-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, "a.go")
+	src := "package a\n\nfunc f() {\n\ts := \"é\"; g(s)\n\tg(s)\n}\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	matches := []asq.Match{
+		{File: file, Row: 4, Col: 12, StartByte: 34, Text: "g(s)"},
+		{File: file, Row: 5, Col: 1, StartByte: 40, Text: "g(s)"},
+		{File: "missing.go", Row: 2, Col: 0, Text: "h(\n)"},
+	}
+
+	tests := []struct {
+		name     string
+		opts     output.Options
+		expected string
+	}{
+		{
+			name: "vimgrep",
+			opts: output.Options{Format: "vimgrep"},
+			expected: file + ":4:13:\ts := \"é\"; g(s)\n" +
+				file + ":5:2:\tg(s)\n" +
+				"missing.go:2:1:h(\n",
+		},
+		{
+			// screen columns count the tab as eight and "é" as one
+			name: "emacs",
+			opts: output.Options{Format: "emacs"},
+			expected: file + ":4:19: \ts := \"é\"; g(s)\n" +
+				file + ":5:9: \tg(s)\n" +
+				"missing.go:2:1: h(\n",
+		},
+		{
+			name:     "files-with-matches",
+			opts:     output.Options{Format: "vimgrep", FilesWithMatches: true},
+			expected: file + "\nmissing.go\n",
+		},
+		{
+			name:     "count",
+			opts:     output.Options{Count: true},
+			expected: file + ":2\nmissing.go:1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := render(t, tt.opts, matches); got != tt.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestColumnEncodings(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-output-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// g() starts after 15 bytes, 11 characters, 12 UTF-16 code units or 18
+	// screen columns
+	file := filepath.Join(tmpDir, "a.go")
+	src := "package a\n\nfunc f() {\n\t_ = \"é😀\"; g()\n}\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	searched, err := asq.ValidateTreeSitterQuery(file, `(call_expression) @x`)
+	if err != nil || len(searched) != 1 {
+		t.Fatalf("Expected one match, got %v (%v)", searched, err)
+	}
+	// Columns are counted in the file, so matches built by hand get the same
+	m := searched[0]
+	built := []asq.Match{{File: m.File, Row: m.Row, Col: m.Col, EndRow: m.EndRow, EndCol: m.EndCol, StartByte: m.StartByte, EndByte: m.EndByte, Code: m.Code, Text: m.Text}}
+
+	tests := []struct {
+		name     string
+		opts     output.Options
+		expected string
+	}{
+		{"jsonl", output.Options{Format: "jsonl"}, `"start":{"line":4,"column":15,`},
+		{"jsonl utf-8", output.Options{Format: "jsonl", Columns: asq.ColumnUTF8}, `"start":{"line":4,"column":11,`},
+		{"jsonl utf-16", output.Options{Format: "jsonl", Columns: asq.ColumnUTF16}, `"start":{"line":4,"column":12,`},
+		{"text utf-16", output.Options{Columns: asq.ColumnUTF16}, "//asq_match " + file + ":4:12\n"},
+		{"vimgrep", output.Options{Format: "vimgrep"}, file + ":4:16:"},
+		{"vimgrep utf-16", output.Options{Format: "vimgrep", Columns: asq.ColumnUTF16}, file + ":4:13:"},
+		{"emacs", output.Options{Format: "emacs"}, file + ":4:19: "},
+		{"emacs utf-8", output.Options{Format: "emacs", Columns: asq.ColumnUTF8}, file + ":4:12: "},
+		{"sarif", output.Options{Format: "sarif"}, `"startColumn": 13,`},
+		{"sarif utf-8", output.Options{Format: "sarif", Columns: asq.ColumnUTF8}, `"startColumn": 12,`},
+		{"template utf-8", output.Options{Template: "{{.Start.Column}}", Columns: asq.ColumnUTF8}, "11\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, matches := range [][]asq.Match{searched, built} {
+				if got := render(t, tt.opts, matches); !strings.Contains(got, tt.expected) {
+					t.Errorf("Expected output containing %s, got:\n%s", tt.expected, got)
+				}
+			}
+		})
+	}
+
+	if got := render(t, output.Options{Format: "sarif", Columns: asq.ColumnUTF8}, searched); !strings.Contains(got, `"columnKind": "unicodeCodePoints"`) {
+		t.Errorf("Expected SARIF to count code points, got:\n%s", got)
+	}
+	if _, err := output.New(&bytes.Buffer{}, output.Options{Format: "sarif", Columns: asq.ColumnBytes}); err == nil {
+		t.Errorf("Expected an error for SARIF byte columns")
+	}
+}
+
+func TestTextContext(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-output-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, "a.go")
+	src := "package a\n\nfunc f() {\n\tg(1)\n\tg(2)\n\tx := 1\n\ty := 2\n\tz := 3\n\tg(3)\n}\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	var matches []asq.Match
+	for m, err := range asq.MatchFile(context.Background(), file, `(call_expression function: (identifier) @_asq_F arguments: (argument_list) @args) @x`) {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		matches = append(matches, m)
+	}
+
+	tests := []struct {
+		name     string
+		opts     output.Options
+		expected string
+	}{
+		{
+			name: "context",
+			opts: output.Options{Before: 1, After: 1},
+			expected: file + "\n" +
+				" 3-func f() {\n" +
+				" 4:\tg(1)\n" +
+				" 5:\tg(2)\n" +
+				" 6-\tx := 1\n" +
+				"--\n" +
+				" 8-\tz := 3\n" +
+				" 9:\tg(3)\n" +
+				"10-}\n",
+		},
+		{
+			// Colour keeps the layout and highlights only metavariables
+			name: "color",
+			opts: output.Options{Color: true},
+			expected: "//asq_match \x1b[35m" + file + "\x1b[0m:\x1b[32m4:1\x1b[0m\n\x1b[1;33mg\x1b[0m(1)\n" +
+				"//asq_match \x1b[35m" + file + "\x1b[0m:\x1b[32m5:1\x1b[0m\n\x1b[1;33mg\x1b[0m(2)\n" +
+				"//asq_match \x1b[35m" + file + "\x1b[0m:\x1b[32m9:1\x1b[0m\n\x1b[1;33mg\x1b[0m(3)\n",
+		},
+		{
+			name: "context and color",
+			opts: output.Options{After: 1, Color: true},
+			expected: "\x1b[35m" + file + "\x1b[0m\n" +
+				"\x1b[32m 4\x1b[0m:\t\x1b[1;33mg\x1b[0m\x1b[1;31m(1)\x1b[0m\n" +
+				"\x1b[32m 5\x1b[0m:\t\x1b[1;33mg\x1b[0m\x1b[1;31m(2)\x1b[0m\n" +
+				"\x1b[32m 6\x1b[0m-\tx := 1\n" +
+				"--\n" +
+				"\x1b[32m 9\x1b[0m:\t\x1b[1;33mg\x1b[0m\x1b[1;31m(3)\x1b[0m\n" +
+				"\x1b[32m10\x1b[0m-}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := render(t, tt.opts, matches); got != tt.expected {
+				t.Errorf("Expected:\n%q\nGot:\n%q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-output-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, "a.go")
+	src := "package a\n\nfunc f() {\n\tg(1)\n}\n\nfunc h() {\n\tg(\n\t\t2)\n}\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	var matches []asq.Match
+	for m, err := range asq.MatchFile(context.Background(), file, `(call_expression function: (identifier) @_asq_F) @x`) {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		m.Pattern = "calls"
+		matches = append(matches, m)
+	}
+
+	opts := output.Options{
+		Patterns: []asq.PatternInfo{{ID: "calls", Message: "Calls to g"}},
+		Header:   "# {{(index .Patterns 0).Message}}",
+		Template: `{{.Index}}. {{.Pattern}} {{.Start.Line}}:{{.Start.Column}}-{{.End.Line}}:{{.End.Column}} {{.Function}} {{.Capture "_asq_F"}} {{oneline .Text}} {{json .Code}} {{with .Group}}{{.StartLine}}-{{.EndLine}} {{.IsFunction}}{{end}}`,
+		Footer:   "{{.Matches}} matches in {{.Files}} files",
+	}
+	expected := "# Calls to g\n" +
+		"1. calls 4:1-4:5 f g g(1) \"g(1)\" 3-5 true\n" +
+		"2. calls 8:1-9:4 h g g( 2) \"g(\\n\\t\\t2)\" 7-10 true\n" +
+		"2 matches in 1 files\n"
+	if got := render(t, opts, matches); got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+
+	if _, err := output.New(&bytes.Buffer{}, output.Options{Template: "{{.File"}); err == nil {
+		t.Error("Expected an error for an invalid template")
+	}
+	if _, err := output.New(&bytes.Buffer{}, output.Options{Footer: "{{.Files}}"}); err == nil {
+		t.Error("Expected an error for a footer without a template")
+	}
+}
+
+func TestCursorMaxTokens(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-output-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var src strings.Builder
+	src.WriteString("package a\n\nfunc long() {\n")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&src, "\tx%d := 1\n", i)
+	}
+	src.WriteString("\tg(1)\n")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&src, "\ty%d := 1\n", i)
+	}
+	src.WriteString("}\n\nfunc short() {\n\tg(2)\n\tg(3)\n}\n")
+	file := filepath.Join(tmpDir, "a.go")
+	if err := os.WriteFile(file, []byte(src.String()), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	var matches []asq.Match
+	for m, err := range asq.MatchFile(context.Background(), file, `(call_expression function: (identifier) @_asq_F) @x`) {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		matches = append(matches, m)
+	}
+
+	block := func(snippet string) string {
+		return "<especially_relevant_code_snippet>\ngo\n" + file + "\n" + snippet + "\n</especially_relevant_code_snippet>\n\n"
+	}
+	short := block("func short() {\n\tg(2)\n\tg(3)\n}")
+	elided := block("func long() {\n\t// ...\n\tx19 := 1\n\tg(1)\n\ty0 := 1\n\t// ...\n}")
+	minimal := block("func long() {\n\t// ...\n\tg(1)\n\t// ...\n}")
+	budget := output.EstimateTokens(short) + output.EstimateTokens(elided)
+	tooSmall := output.EstimateTokens(short) + output.EstimateTokens(minimal) - 1
+
+	tests := []struct {
+		name      string
+		maxTokens int
+		expected  string
+	}{
+		{
+			// the short function ranks first for its two matches, the long
+			// one is cut down to the lines next to its match
+			name:      "elided",
+			maxTokens: budget,
+			expected:  elided + short,
+		},
+		{
+			name:      "omitted",
+			maxTokens: tooSmall,
+			expected:  short + fmt.Sprintf("// asq: 1 of 3 matches omitted to fit within %d tokens\n", tooSmall),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := render(t, output.Options{Format: "cursor", MaxTokens: tt.maxTokens}, matches)
+			if got != tt.expected {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	tests := map[string]int{
+		"":                     0,
+		"x":                    1,
+		"foo(bar)":             4,
+		"configuration := 1\n": 8,
+	}
+	for s, expected := range tests {
+		if got := output.EstimateTokens(s); got != expected {
+			t.Errorf("EstimateTokens(%q) = %d, expected %d", s, got, expected)
+		}
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	var old, new strings.Builder
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&old, "line %d\n", i)
+		switch i {
+		case 2:
+			new.WriteString("line two\n")
+		case 15:
+			new.WriteString("inserted\nline 15\n")
+		default:
+			fmt.Fprintf(&new, "line %d\n", i)
+		}
+	}
+	tests := []struct {
+		name     string
+		old, new string
+		expected string
+	}{
+		{
+			name: "hunks",
+			old:  old.String(),
+			new:  new.String(),
+			expected: "diff --git a/dir/a.go b/dir/a.go\n--- a/dir/a.go\n+++ b/dir/a.go\n" +
+				"@@ -1,5 +1,5 @@\n line 1\n-line 2\n+line two\n line 3\n line 4\n line 5\n" +
+				"@@ -12,6 +12,7 @@\n line 12\n line 13\n line 14\n+inserted\n line 15\n line 16\n line 17\n",
+		},
+		{
+			name: "no newline at end of file",
+			old:  "a\nb",
+			new:  "a\nc",
+			expected: "diff --git a/dir/a.go b/dir/a.go\n--- a/dir/a.go\n+++ b/dir/a.go\n" +
+				"@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			name: "unchanged",
+			old:  "a\n",
+			new:  "a\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := output.WriteDiff(&buf, "./dir/a.go", []byte(tt.old), []byte(tt.new), false); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := buf.String(); got != tt.expected {
+				t.Errorf("Expected diff:\n%s\ngot:\n%s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestReviewer(t *testing.T) {
+	src := "package a\n\nfunc f() {\n\tg(a)\n\tg(b)\n\tg(c)\n}\n"
+	tests := []struct {
+		name     string
+		answers  string
+		expected string
+		quit     bool
+	}{
+		{
+			name:     "yes and no",
+			answers:  "y\nn\ny\n",
+			expected: "package a\n\nfunc f() {\n\th(a)\n\tg(b)\n\th(c)\n}\n",
+		},
+		{
+			name:     "all",
+			answers:  "n\na\n",
+			expected: "package a\n\nfunc f() {\n\tg(a)\n\th(b)\n\th(c)\n}\n",
+		},
+		{
+			name:     "quit",
+			answers:  "y\nq\n",
+			expected: "package a\n\nfunc f() {\n\th(a)\n\tg(b)\n\tg(c)\n}\n",
+			quit:     true,
+		},
+		{
+			name:     "edit",
+			answers:  "?\ne\nk(b,\n\t1)\n.\ny\nn\nn\n",
+			expected: "package a\n\nfunc f() {\n\tk(b,\n\t\t1)\n\tg(b)\n\tg(c)\n}\n",
+		},
+		{
+			name:     "end of input",
+			answers:  "y",
+			expected: "package a\n\nfunc f() {\n\th(a)\n\tg(b)\n\tg(c)\n}\n",
+			quit:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("", "asq-review-*")
+			if err != nil {
+				t.Fatalf("Failed to create temp directory: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			file := filepath.Join(tmpDir, "a.go")
+			if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+			matches, err := asq.ValidateTreeSitterQuery(file, `(call_expression arguments: (argument_list (identifier) @_asq_A)) @x`)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			fr, err := asq.RewriteFile(file, matches, asq.NewReplacement("h(_asq_A)"))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var out bytes.Buffer
+			rv := output.NewReviewer(strings.NewReader(tt.answers), &out, false)
+			reviewed, err := rv.Review(fr)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := string(reviewed.Rewritten); got != tt.expected {
+				t.Errorf("Expected rewritten file:\n%s\ngot:\n%s\noutput:\n%s", tt.expected, got, out.String())
+			}
+			if rv.Quit() != tt.quit {
+				t.Errorf("Expected Quit() = %v, got %v", tt.quit, rv.Quit())
+			}
+			if rejected := len(fr.Edits) - len(reviewed.Edits); len(reviewed.Skipped) != rejected {
+				t.Errorf("Expected %d rejected matches, got %d", rejected, len(reviewed.Skipped))
+			}
+		})
+	}
+}
+
+func TestWriteExplanation(t *testing.T) {
+	e, err := asq.ExplainInlinePattern(context.Background(), `foo(/***/x, "s")`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := output.WriteExplanation(&buf, e); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `pattern inline.go
+
+region:
+  1 | foo(/***/x, "s")
+
+nodes:
+  CallExpr 1:1-1:17 foo(/***/x, "s")
+    Ident 1:1-1:4 foo
+    Ident 1:10-1:11 x [wildcard]
+    DefaultExpr *ast.BasicLit 1:13-1:16 "s"
+
+wildcards:
+  /***/ at 1:5, to 2:2: makes x at 1:10 a wildcard
+
+query:
+  (call_expression                                   ; 1:1 foo(/***/x, "s")
+    function: (identifier) @name (#eq? @name "foo")  ; 1:1 foo
+    arguments: (argument_list)) @x                   ; 1:1 foo(/***/x, "s")
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("Expected explanation:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestWritePatternTests(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-output-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		"_asq_calls.go": "package p\n\nfunc asq_calls() {\n\t//asq_start\n\tfmt.Println(/***/x)\n\t//asq_end\n}\n",
+		"calls.go":      "package p\n\nfunc a() {\n\tfmt.Println(1) // asq:expect\n\t// asq:expect\n\tx := 2\n}\n",
+		"_asq_ok.go":    "package p\n\nfunc asq_ok() {\n\t//asq_start\n\tfoo()\n\t//asq_end\n}\n",
+		"ok.go":         "package p\n\nfunc b() {\n\tfoo() // asq:expect\n}\n",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	tests, err := asq.RunPatternTests(context.Background(), tmpDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := output.WritePatternTests(&buf, tests); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := fmt.Sprintf(`FAIL  calls  %[1]s/_asq_calls.go
+    %[1]s/calls.go (- missing, + unexpected):
+      -   6 | 	x := 2
+ok    ok  %[1]s/_asq_ok.go (1 fixture, 1 match)
+FAIL  1 of 2 patterns failed
+`, tmpDir)
+	if got := buf.String(); got != expected {
+		t.Errorf("Expected report:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestGroupsMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     output.Options
+		expected bool
+	}{
+		{"text", output.Options{}, false},
+		{"jsonl", output.Options{Format: "jsonl"}, false},
+		{"cursor", output.Options{Format: "cursor"}, true},
+		{"cursor count", output.Options{Format: "cursor", Count: true}, false},
+		{"template", output.Options{Template: "{{.File}}"}, false},
+		{"template with group", output.Options{Format: "cursor", Template: "{{with .Group}}{{.StartLine}}{{end}}"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.GroupsMatches(); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
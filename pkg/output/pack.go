@@ -0,0 +1,161 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+// elisionRadii are the numbers of lines kept around each match, tried in
+// turn when a group does not fit the remaining token budget.
+var elisionRadii = []int{8, 4, 2, 1, 0}
+
+// EstimateTokens approximates the number of tokens a language model's
+// tokenizer produces for s: one per four characters of a word or number,
+// one per other symbol and one per newline. Other whitespace is free.
+func EstimateTokens(s string) int {
+	tokens, word := 0, 0
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			word++
+			continue
+		}
+		tokens += (word + 3) / 4
+		word = 0
+		if r == '\n' || !unicode.IsSpace(r) {
+			tokens++
+		}
+	}
+	return tokens + (word+3)/4
+}
+
+// packedGroup is a cursor group waiting to be packed into the token budget.
+type packedGroup struct {
+	group  asq.MatchGroup
+	header string
+	order  int // position in the unpacked output
+}
+
+// pack writes the highest ranked groups that fit in c.maxTokens, in their
+// original order, followed by a note of how many matches were left out.
+// Groups with more matches rank first, then smaller ones. A group that does
+// not fit is shortened by eliding the lines far from its matches; packing
+// stops at the first group that does not fit even then.
+func (c *cursorWriter) pack() error {
+	ranked := make([]packedGroup, len(c.packed))
+	copy(ranked, c.packed)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i].group, ranked[j].group
+		if len(a.Matches) != len(b.Matches) {
+			return len(a.Matches) > len(b.Matches)
+		}
+		return EstimateTokens(a.Snippet) < EstimateTokens(b.Snippet)
+	})
+
+	budget := c.maxTokens
+	var chosen []packedGroup
+	total, omitted := 0, 0
+	for _, p := range ranked {
+		total += len(p.group.Matches)
+		if omitted > 0 {
+			omitted += len(p.group.Matches)
+			continue
+		}
+		snippet, ok := fitGroup(p, budget)
+		if !ok {
+			omitted += len(p.group.Matches)
+			continue
+		}
+		p.group.Snippet = snippet
+		budget -= EstimateTokens(cursorBlock(p.header, snippet))
+		chosen = append(chosen, p)
+	}
+
+	sort.Slice(chosen, func(i, j int) bool { return chosen[i].order < chosen[j].order })
+	for _, p := range chosen {
+		if _, err := fmt.Fprint(c.w, cursorBlock(p.header, p.group.Snippet)); err != nil {
+			return err
+		}
+	}
+	if omitted > 0 {
+		_, err := fmt.Fprintf(c.w, "// asq: %d of %d matches omitted to fit within %d tokens\n", omitted, total, c.maxTokens)
+		return err
+	}
+	return nil
+}
+
+// fitGroup returns the snippet of p, shortened as little as possible to fit
+// in budget tokens, and whether it fits at all.
+func fitGroup(p packedGroup, budget int) (string, bool) {
+	if EstimateTokens(cursorBlock(p.header, p.group.Snippet)) <= budget {
+		return p.group.Snippet, true
+	}
+	for _, radius := range elisionRadii {
+		snippet, ok := elide(p.group, radius)
+		if !ok {
+			break
+		}
+		if EstimateTokens(cursorBlock(p.header, snippet)) <= budget {
+			return snippet, true
+		}
+	}
+	return "", false
+}
+
+// elide keeps the first and last lines of the group's snippet and those within
+// radius lines of a match, replacing each run of other lines by a "// ..."
+// line. It reports false if the snippet's lines do not map one to one onto
+// the lines of the file, as for root-level groups that skip functions.
+func elide(g asq.MatchGroup, radius int) (string, bool) {
+	lines := strings.Split(g.Snippet, "\n")
+	if len(lines) != g.EndLine-g.StartLine+1 {
+		return "", false
+	}
+
+	keep := make([]bool, len(lines))
+	keep[0], keep[len(lines)-1] = true, true
+	for _, m := range g.Matches {
+		for row := m.Row - radius; row <= m.EndRow+radius; row++ {
+			if i := row - g.StartLine; i >= 0 && i < len(lines) {
+				keep[i] = true
+			}
+		}
+	}
+
+	var out []string
+	for i := 0; i < len(lines); {
+		if keep[i] {
+			out = append(out, lines[i])
+			i++
+			continue
+		}
+		j := i
+		for !keep[j] {
+			j++
+		}
+		if j-i == 1 {
+			// A marker would not be any shorter than the line itself
+			out = append(out, lines[i])
+		} else {
+			indent := leadingSpace(lines[i-1])
+			if next := leadingSpace(lines[j]); len(next) > len(indent) {
+				indent = next
+			}
+			out = append(out, indent+"// ...")
+		}
+		i = j
+	}
+	return strings.Join(out, "\n"), true
+}
+
+func leadingSpace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// cursorBlock wraps a group's snippet for the cursor format.
+func cursorBlock(header, snippet string) string {
+	return fmt.Sprintf("<especially_relevant_code_snippet>\ngo\n%s\n%s\n</especially_relevant_code_snippet>\n\n", header, snippet)
+}
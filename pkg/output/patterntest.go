@@ -0,0 +1,63 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+// WritePatternTests writes a report of pattern tests: a line per pattern,
+// starting with "ok" or "FAIL", followed for failed patterns by the reason or,
+// for each failed fixture, the lines where the matches differ from its
+// annotations. A last line sums up the run.
+func WritePatternTests(w io.Writer, tests []*asq.PatternTest) error {
+	bw := bufio.NewWriter(w)
+	failed := 0
+	for _, t := range tests {
+		if t.Passed() {
+			matches := 0
+			for _, f := range t.Fixtures {
+				matches += len(f.Matched)
+			}
+			fmt.Fprintf(bw, "ok    %s  %s (%s, %s)\n", t.Name, t.Pattern, plural(len(t.Fixtures), "fixture"), plural(matches, "match"))
+			continue
+		}
+		failed++
+		fmt.Fprintf(bw, "FAIL  %s  %s\n", t.Name, t.Pattern)
+		if t.Err != nil {
+			fmt.Fprintf(bw, "    error: %v\n", t.Err)
+			continue
+		}
+		for _, f := range t.Fixtures {
+			switch {
+			case f.Err != nil:
+				fmt.Fprintf(bw, "    %s: error: %v\n", f.File, f.Err)
+			case !f.Passed():
+				fmt.Fprintf(bw, "    %s (- missing, + unexpected):\n", f.File)
+				for _, line := range strings.SplitAfter(strings.TrimSuffix(f.Diff(), "\n"), "\n") {
+					fmt.Fprintf(bw, "      %s", line)
+				}
+				fmt.Fprintln(bw)
+			}
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(bw, "FAIL  %d of %s failed\n", failed, plural(len(tests), "pattern"))
+	} else {
+		fmt.Fprintf(bw, "ok    %s passed\n", plural(len(tests), "pattern"))
+	}
+	return bw.Flush()
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	if strings.HasSuffix(noun, "ch") {
+		return fmt.Sprintf("%d %ses", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,144 @@
+package output
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+const reviewHelp = `y - apply this edit
+n - do not apply this edit
+a - apply this edit and all later ones
+q - quit; do not apply this edit or any later one
+e - change the replacement code of this edit
+? - print help
+`
+
+// Reviewer steps through the edits of rewrites like git add -p does through
+// hunks: it shows each edit as a diff with its context and asks whether to
+// apply it. Answers are read from an input stream, one per line, so that a
+// review can be scripted.
+type Reviewer struct {
+	in    *bufio.Reader
+	out   io.Writer
+	color bool
+
+	// EditText lets the user change the replacement code of an edit, given
+	// the current code. By default the new code is read from the input, up to
+	// a line holding a single ".".
+	EditText func(text string) (string, error)
+
+	all  bool // apply the remaining edits without asking
+	quit bool // apply no more edits
+}
+
+// NewReviewer returns a Reviewer that reads answers from in and writes
+// diffs and questions to out, coloured if color is set.
+func NewReviewer(in io.Reader, out io.Writer, color bool) *Reviewer {
+	return &Reviewer{in: bufio.NewReader(in), out: out, color: color}
+}
+
+// Quit reports whether the user quit the review. Later rewrites are left
+// without edits.
+func (rv *Reviewer) Quit() bool {
+	return rv.quit
+}
+
+// Review asks for each edit of fr whether to apply it, and returns the rewrite
+// with only the edits accepted. The end of the input quits the review.
+func (rv *Reviewer) Review(fr *asq.FileRewrite) (*asq.FileRewrite, error) {
+	var accepted []asq.Edit
+	edits := slices.Clone(fr.Edits)
+	for i := 0; i < len(edits) && !rv.quit; i++ {
+		e := edits[i]
+		if rv.all {
+			accepted = append(accepted, e)
+			continue
+		}
+
+		preview, err := fr.With([]asq.Edit{e})
+		if err != nil {
+			return nil, err
+		}
+		if err := WriteDiff(rv.out, fr.Path, fr.Original, preview, rv.color); err != nil {
+			return nil, err
+		}
+		for answered := false; !answered; {
+			fmt.Fprintf(rv.out, "(%d/%d) Apply this edit [y,n,a,q,e,?]? ", i+1, len(edits))
+			answer, err := rv.in.ReadString('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			if err != nil && answer == "" {
+				fmt.Fprintln(rv.out)
+				answer = "q"
+			}
+
+			answered = true
+			switch strings.TrimSpace(answer) {
+			case "y":
+				accepted = append(accepted, e)
+			case "n":
+			case "a":
+				accepted = append(accepted, e)
+				rv.all = true
+			case "q":
+				rv.quit = true
+			case "e":
+				// Show the edit again with the new code
+				if edited, ok := rv.editEdit(fr, e); ok {
+					edits[i] = edited
+				}
+				i--
+			default:
+				fmt.Fprint(rv.out, reviewHelp)
+				answered = false
+			}
+		}
+	}
+	return fr.Only(accepted)
+}
+
+// editEdit returns e with the replacement code the user gives, or false if
+// the code could not be read or makes invalid Go, which is reported.
+func (rv *Reviewer) editEdit(fr *asq.FileRewrite, e asq.Edit) (asq.Edit, bool) {
+	editText := rv.EditText
+	if editText == nil {
+		editText = rv.readText
+	}
+	text, err := editText(e.Text)
+	if err == nil {
+		e.Text = text
+		_, err = fr.With([]asq.Edit{e})
+	}
+	if err != nil {
+		fmt.Fprintf(rv.out, "Edit not changed: %v\n", err)
+		return e, false
+	}
+	return e, true
+}
+
+// readText reads replacement code from the input, up to a line holding a
+// single ".".
+func (rv *Reviewer) readText(text string) (string, error) {
+	fmt.Fprintf(rv.out, "Replacement code, ending with a line holding a single \".\":\n%s\n", text)
+	var lines []string
+	for {
+		line, err := rv.in.ReadString('\n')
+		if strings.TrimRight(line, "\r\n") == "." {
+			return strings.Join(lines, "\n"), nil
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+	}
+}
@@ -0,0 +1,242 @@
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	// sarifFingerprint names the partial fingerprint asq computes for each
+	// result. Bump the version if the way it is computed changes.
+	sarifFingerprint = "asqMatchHash/v1"
+)
+
+// The types below cover the subset of SARIF 2.1.0 written by asq.
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool     `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name,omitempty"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	RuleIndex           int               `json:"ruleIndex"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int          `json:"startLine"`
+	StartColumn int          `json:"startColumn"`
+	EndLine     int          `json:"endLine"`
+	EndColumn   int          `json:"endColumn"`
+	ByteOffset  int          `json:"byteOffset"`
+	ByteLength  int          `json:"byteLength"`
+	Snippet     sarifMessage `json:"snippet"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// sarifWriter collects results and writes a single SARIF log on Close. Every
+// pattern in Options.Patterns becomes a rule, even if it did not match.
+type sarifWriter struct {
+	w     io.Writer
+	rules []sarifRule
+	index map[string]int // rule index by pattern ID
+	run   sarifRun
+
+	// source of the file whose matches are being written, used to convert
+	// byte columns into the UTF-16 code units or characters SARIF counts
+	source  source
+	columns asq.ColumnEncoding
+	// occurrences counts results with the same fingerprint input per file, so
+	// that identical matches in one function still get distinct fingerprints
+	occurrences map[string]int
+}
+
+func newSARIFWriter(w io.Writer, opts Options) (*sarifWriter, error) {
+	s := &sarifWriter{w: w, index: make(map[string]int), columns: opts.Columns}
+	switch s.columns {
+	case 0:
+		s.columns = asq.ColumnUTF16
+	case asq.ColumnBytes:
+		return nil, fmt.Errorf("sarif columns count UTF-16 code units or characters, not bytes")
+	}
+	for _, p := range opts.Patterns {
+		s.addRule(p)
+	}
+	return s, nil
+}
+
+func (s *sarifWriter) addRule(p asq.PatternInfo) int {
+	if i, ok := s.index[p.ID]; ok {
+		return i
+	}
+	if p.Severity == "" {
+		p.Severity = asq.SeverityWarning
+	}
+	description := p.Message
+	if description == "" {
+		description = fmt.Sprintf("Matches the asq pattern %s", p.ID)
+	}
+	s.index[p.ID] = len(s.rules)
+	s.rules = append(s.rules, sarifRule{
+		ID:                   p.ID,
+		Name:                 p.Name,
+		ShortDescription:     sarifMessage{Text: description},
+		DefaultConfiguration: sarifConfiguration{Level: p.Severity},
+	})
+	return s.index[p.ID]
+}
+
+func (s *sarifWriter) WriteMatch(m asq.Match) error {
+	if s.source.load(m.File) {
+		s.occurrences = make(map[string]int)
+	}
+
+	id := m.Pattern
+	if id == "" {
+		id = "asq"
+	}
+	ruleIndex, ok := s.index[id]
+	if !ok {
+		ruleIndex = s.addRule(asq.PatternInfo{ID: id})
+	}
+	rule := s.rules[ruleIndex]
+
+	location := sarifLocation{
+		PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifact(m.File),
+			Region: sarifRegion{
+				StartLine:   m.Row,
+				StartColumn: s.source.column(m, m.StartByte, m.Col, s.columns) + 1,
+				EndLine:     m.EndRow,
+				EndColumn:   s.source.column(m, m.EndByte, m.EndCol, s.columns) + 1,
+				ByteOffset:  m.StartByte,
+				ByteLength:  m.EndByte - m.StartByte,
+				Snippet:     sarifMessage{Text: m.Text},
+			},
+		},
+	}
+	if m.Function != "" {
+		location.LogicalLocations = []sarifLogicalLocation{{FullyQualifiedName: m.Function, Kind: "function"}}
+	}
+
+	s.run.Results = append(s.run.Results, sarifResult{
+		RuleID:              rule.ID,
+		RuleIndex:           ruleIndex,
+		Level:               rule.DefaultConfiguration.Level,
+		Message:             rule.ShortDescription,
+		Locations:           []sarifLocation{location},
+		PartialFingerprints: map[string]string{sarifFingerprint: s.fingerprint(rule.ID, location.PhysicalLocation.ArtifactLocation.URI, m)},
+	})
+	return nil
+}
+
+// fingerprint identifies a result independently of its line, so that code
+// scanning services can track it while surrounding code moves. It hashes the
+// rule, the file, the enclosing function and the matched text with its
+// whitespace collapsed, plus a counter for repeats of the same text.
+func (s *sarifWriter) fingerprint(ruleID, uri string, m asq.Match) string {
+	key := strings.Join([]string{ruleID, uri, m.Function, strings.Join(strings.Fields(m.Text), " ")}, "\x00")
+	n := s.occurrences[key]
+	s.occurrences[key] = n + 1
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d", key, n)))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (s *sarifWriter) Close() error {
+	s.run.Tool = sarifTool{Driver: sarifDriver{
+		Name:           "asq",
+		InformationURI: "https://github.com/StCredZero/asq",
+		Rules:          s.rules,
+	}}
+	if s.run.Tool.Driver.Rules == nil {
+		s.run.Tool.Driver.Rules = []sarifRule{}
+	}
+	if s.run.Results == nil {
+		s.run.Results = []sarifResult{}
+	}
+	s.run.ColumnKind = "utf16CodeUnits"
+	if s.columns == asq.ColumnUTF8 {
+		s.run.ColumnKind = "unicodeCodePoints"
+	}
+
+	enc := json.NewEncoder(s.w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{Version: sarifVersion, Schema: sarifSchema, Runs: []sarifRun{s.run}})
+}
+
+// sarifArtifact locates path relative to the source root, or by absolute
+// file URI if path is absolute.
+func sarifArtifact(path string) sarifArtifactLocation {
+	if filepath.IsAbs(path) {
+		u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+		return sarifArtifactLocation{URI: u.String()}
+	}
+	u := url.URL{Path: filepath.ToSlash(filepath.Clean(path))}
+	return sarifArtifactLocation{URI: u.String(), URIBaseID: "%SRCROOT%"}
+}
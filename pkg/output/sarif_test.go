@@ -0,0 +1,299 @@
+package output_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/StCredZero/asq/pkg/asq"
+	"github.com/StCredZero/asq/pkg/output"
+)
+
+func TestSARIF(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "asq-sarif-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	src := "package a\n\nfunc f() {\n\ts := \"é\"; g(s)\n\tg(s)\n}\n"
+	file := filepath.Join(tmpDir, "a.go")
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	query := `(call_expression function: (identifier) @fn) @x`
+	var matches []asq.Match
+	for m, err := range asq.Search(context.Background(), query, func(yield func(string) bool) { yield(file) }, asq.SearchOptions{Pattern: "no-g"}) {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		matches = append(matches, m)
+	}
+	patterns := []asq.PatternInfo{
+		{Name: "no_g", ID: "no-g", Message: "Do not call g.", Severity: asq.SeverityError},
+		{Name: "unused", ID: "unused", Severity: asq.SeverityNote},
+	}
+
+	log := render(t, output.Options{Format: "sarif", Patterns: patterns}, matches)
+	validateSARIF(t, log)
+
+	var doc struct {
+		Runs []struct {
+			Tool struct {
+				Driver struct {
+					Rules []struct {
+						ID string `json:"id"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID    string                `json:"ruleId"`
+				Level     string                `json:"level"`
+				Message   struct{ Text string } `json:"message"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string } `json:"artifactLocation"`
+						Region           struct {
+							StartLine   int `json:"startLine"`
+							StartColumn int `json:"startColumn"`
+							EndColumn   int `json:"endColumn"`
+						} `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+				PartialFingerprints map[string]string `json:"partialFingerprints"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal([]byte(log), &doc); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	run := doc.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 || run.Tool.Driver.Rules[1].ID != "unused" {
+		t.Errorf("Expected a rule per pattern, got %+v", run.Tool.Driver.Rules)
+	}
+	if len(run.Results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(run.Results))
+	}
+	first, second := run.Results[0], run.Results[1]
+	if first.RuleID != "no-g" || first.Level != "error" || first.Message.Text != "Do not call g." {
+		t.Errorf("Unexpected result %+v", first)
+	}
+	region := first.Locations[0].PhysicalLocation.Region
+	// "é" is two bytes but a single UTF-16 code unit
+	if region.StartLine != 4 || region.StartColumn != 12 || region.EndColumn != 16 {
+		t.Errorf("Unexpected region %+v", region)
+	}
+	if uri := first.Locations[0].PhysicalLocation.ArtifactLocation.URI; !strings.HasPrefix(uri, "file:///") || !strings.HasSuffix(uri, "/a.go") {
+		t.Errorf("Unexpected URI %q", uri)
+	}
+	fp := first.PartialFingerprints["asqMatchHash/v1"]
+	if fp == "" || fp == second.PartialFingerprints["asqMatchHash/v1"] {
+		t.Errorf("Expected distinct fingerprints for identical matches, got %q and %q", fp, second.PartialFingerprints["asqMatchHash/v1"])
+	}
+
+	// Fingerprints must survive code moving to other lines
+	shifted := make([]asq.Match, len(matches))
+	for i, m := range matches {
+		m.Row += 3
+		m.EndRow += 3
+		m.StartByte += 3
+		m.EndByte += 3
+		shifted[i] = m
+	}
+	if err := os.WriteFile(file, []byte("\n\n\n"+src), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	moved := render(t, output.Options{Format: "sarif", Patterns: patterns}, shifted)
+	if !strings.Contains(moved, fp) {
+		t.Errorf("Fingerprint %s changed after shifting lines", fp)
+	}
+}
+
+func TestSARIFEmpty(t *testing.T) {
+	validateSARIF(t, render(t, output.Options{Format: "sarif"}, nil))
+}
+
+// validateSARIF checks log against the SARIF 2.1.0 JSON schema.
+func validateSARIF(t *testing.T, log string) {
+	t.Helper()
+	schemaJSON, err := os.ReadFile(filepath.Join("testdata", "sarif-schema-2.1.0.json"))
+	if err != nil {
+		t.Fatalf("Failed to read schema: %v", err)
+	}
+	var schema, doc any
+	if err := json.Unmarshal(schemaJSON, &schema); err != nil {
+		t.Fatalf("Invalid schema: %v", err)
+	}
+	if err := json.Unmarshal([]byte(log), &doc); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, log)
+	}
+	v := &schemaValidator{root: schema.(map[string]any)}
+	for _, err := range v.validate(schema, doc, "$") {
+		t.Errorf("Schema violation: %v", err)
+	}
+}
+
+// schemaValidator implements the parts of JSON Schema draft 04 used by the
+// SARIF schema. Annotations such as format and default are not checked.
+type schemaValidator struct {
+	root map[string]any
+}
+
+func (v *schemaValidator) validate(schema, value any, path string) []error {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return nil
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		return v.validate(v.resolve(ref), value, path)
+	}
+
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	if typ, ok := s["type"]; ok && !hasType(typ, value) {
+		fail("expected type %v, got %T", typ, value)
+		return errs
+	}
+	if enum, ok := s["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			found = found || reflect.DeepEqual(e, value)
+		}
+		if !found {
+			fail("%v is not one of %v", value, enum)
+		}
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		alternatives, ok := s[key].([]any)
+		if !ok {
+			continue
+		}
+		valid := 0
+		for _, alt := range alternatives {
+			if len(v.validate(alt, value, path)) == 0 {
+				valid++
+			}
+		}
+		if valid == 0 || (key == "oneOf" && valid > 1) {
+			fail("%d alternatives of %s match", valid, key)
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		for _, req := range asSlice(s["required"]) {
+			if _, ok := value[req.(string)]; !ok {
+				fail("missing required property %q", req)
+			}
+		}
+		props, _ := s["properties"].(map[string]any)
+		for name, child := range value {
+			if prop, ok := props[name]; ok {
+				errs = append(errs, v.validate(prop, child, path+"."+name)...)
+				continue
+			}
+			switch additional := s["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					fail("unexpected property %q", name)
+				}
+			case map[string]any:
+				errs = append(errs, v.validate(additional, child, path+"."+name)...)
+			}
+		}
+	case []any:
+		if min, ok := s["minItems"].(float64); ok && float64(len(value)) < min {
+			fail("expected at least %v items", min)
+		}
+		if unique, _ := s["uniqueItems"].(bool); unique {
+			for i := range value {
+				for j := i + 1; j < len(value); j++ {
+					if reflect.DeepEqual(value[i], value[j]) {
+						fail("items %d and %d are equal", i, j)
+					}
+				}
+			}
+		}
+		for i, item := range value {
+			errs = append(errs, v.validate(s["items"], item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case float64:
+		if min, ok := s["minimum"].(float64); ok && value < min {
+			fail("%v is less than %v", value, min)
+		}
+		if max, ok := s["maximum"].(float64); ok && value > max {
+			fail("%v is greater than %v", value, max)
+		}
+	case string:
+		if pattern, ok := s["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(value) {
+			fail("%q does not match %s", value, pattern)
+		}
+	}
+	return errs
+}
+
+func (v *schemaValidator) resolve(ref string) any {
+	var node any = v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if part != "" {
+			node = node.(map[string]any)[part]
+		}
+	}
+	return node
+}
+
+func hasType(typ, value any) bool {
+	for _, t := range asSlice(typ) {
+		switch t {
+		case "object":
+			if _, ok := value.(map[string]any); ok {
+				return true
+			}
+		case "array":
+			if _, ok := value.([]any); ok {
+				return true
+			}
+		case "string":
+			if _, ok := value.(string); ok {
+				return true
+			}
+		case "boolean":
+			if _, ok := value.(bool); ok {
+				return true
+			}
+		case "number":
+			if _, ok := value.(float64); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := value.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		case "null":
+			if value == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func asSlice(v any) []any {
+	if s, ok := v.([]any); ok {
+		return s
+	}
+	if v == nil {
+		return nil
+	}
+	return []any{v}
+}
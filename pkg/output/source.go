@@ -0,0 +1,60 @@
+package output
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+// source holds the contents of the file whose matches are being written, for
+// formats that print whole lines or convert columns. Matches of a file arrive
+// consecutively, so only the current file is kept.
+type source struct {
+	file     string
+	contents []byte
+}
+
+// load reads file unless it is already loaded. A file that cannot be read
+// leaves contents empty, and callers fall back to what the match records.
+func (s *source) load(file string) (changed bool) {
+	if file == s.file {
+		return false
+	}
+	s.file = file
+	s.contents, _ = os.ReadFile(file)
+	return true
+}
+
+// line returns the line holding the position at byte offset, whose 0-based
+// byte column is col, and the part of that line before the position. Both are
+// nil if the position lies outside the loaded contents.
+func (s *source) line(offset, col int) (line, prefix []byte) {
+	start := offset - col
+	if start < 0 || offset > len(s.contents) {
+		return nil, nil
+	}
+	end := bytes.IndexByte(s.contents[offset:], '\n')
+	if end < 0 {
+		end = len(s.contents)
+	} else {
+		end += offset
+	}
+	line = bytes.TrimSuffix(s.contents[start:end], []byte("\r"))
+	return line, s.contents[start:offset]
+}
+
+// column returns the 0-based column, counted in enc, of the position in the
+// file of m at byte offset, whose byte column is col. It counts in the file
+// as read now, and returns col if the file does not hold the position.
+func (s *source) column(m asq.Match, offset, col int, enc asq.ColumnEncoding) int {
+	if enc == 0 || enc == asq.ColumnBytes {
+		return col
+	}
+	s.load(m.File)
+	_, prefix := s.line(offset, col)
+	if prefix == nil {
+		return col
+	}
+	return enc.Count(prefix)
+}
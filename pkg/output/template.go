@@ -0,0 +1,216 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+// TemplateMatch is the data a match template is executed with. For example
+//
+//	{{.File}}:{{.Start.Line}}: {{oneline .Code}} in {{.Function}}
+//
+// Its output is followed by a newline.
+type TemplateMatch struct {
+	// Index is the 1-based number of the match in the output.
+	Index int
+	// File is the path of the file, as given to or found by the search.
+	File string
+	// Pattern is the ID of the pattern that matched.
+	Pattern string
+	// Function is the enclosing function or method, like "(*T).M", if any.
+	Function string
+	// Start and End delimit the match. End is exclusive.
+	Start, End JSONPosition
+	// Code is the matched code as printed by the text format.
+	Code string
+	// Text is the exact source text of the match.
+	Text string
+	// Captures lists what the metavariables of the pattern matched.
+	Captures []JSONCapture
+
+	group func() (asq.MatchGroup, error)
+}
+
+// Capture returns the text of the first capture named name, or "".
+func (m TemplateMatch) Capture(name string) string {
+	for _, c := range m.Captures {
+		if c.Name == name {
+			return c.Text
+		}
+	}
+	return ""
+}
+
+// Group returns the group of the match in the cursor format: the enclosing
+// function, declaration or statement, with its FilePath, StartLine, EndLine,
+// Snippet, Kind and IsFunction. It is only computed for templates that use it.
+func (m TemplateMatch) Group() (asq.MatchGroup, error) {
+	return m.group()
+}
+
+// TemplateReport is the data the header and footer templates are executed
+// with. Matches and Files are zero in the header.
+type TemplateReport struct {
+	// Patterns describes the patterns searched for.
+	Patterns []asq.PatternInfo
+	// Matches is the number of matches written.
+	Matches int
+	// Files is the number of files with matches.
+	Files int
+}
+
+// templateFuncs are available to every template in addition to the
+// text/template builtins.
+var templateFuncs = template.FuncMap{
+	// json encodes a value as JSON
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// oneline collapses whitespace, including newlines, to single spaces
+	"oneline": func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	},
+	// trim removes leading and trailing whitespace
+	"trim": strings.TrimSpace,
+}
+
+// templateWriter executes a template for each match, and optional header and
+// footer templates before the first and after the last match. The matches of
+// a file are held back until the next file starts, so that their groups can
+// be computed together.
+type templateWriter struct {
+	w              io.Writer
+	match          *template.Template
+	header, footer *template.Template
+	boundary       asq.Boundary
+	columns        asq.ColumnEncoding
+	source         source
+	report         TemplateReport
+	started        bool
+	pending        []asq.Match
+}
+
+func newTemplateWriter(w io.Writer, opts Options) (*templateWriter, error) {
+	t := &templateWriter{w: w, boundary: opts.Boundary, columns: opts.Columns, report: TemplateReport{Patterns: opts.Patterns}}
+	var err error
+	if t.match, err = parseTemplate("match", opts.Template); err != nil {
+		return nil, err
+	}
+	if t.header, err = parseTemplate("header", opts.Header); err != nil {
+		return nil, err
+	}
+	if t.footer, err = parseTemplate("footer", opts.Footer); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// parseTemplate parses text, returning nil if it is empty.
+func parseTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func (t *templateWriter) WriteMatch(m asq.Match) error {
+	if err := t.start(); err != nil {
+		return err
+	}
+	if len(t.pending) > 0 && t.pending[0].File != m.File {
+		if err := t.flush(); err != nil {
+			return err
+		}
+	}
+	t.pending = append(t.pending, m)
+	return nil
+}
+
+func (t *templateWriter) Close() error {
+	if err := t.start(); err != nil {
+		return err
+	}
+	if err := t.flush(); err != nil {
+		return err
+	}
+	return t.execute(t.footer, t.report)
+}
+
+// start writes the header once.
+func (t *templateWriter) start() error {
+	if t.started {
+		return nil
+	}
+	t.started = true
+	return t.execute(t.header, t.report)
+}
+
+func (t *templateWriter) flush() error {
+	if len(t.pending) == 0 {
+		return nil
+	}
+	matches := t.pending
+	t.pending = nil
+	t.report.Files++
+
+	var groups []asq.MatchGroup
+	var groupErr error
+	grouped := false
+	for _, m := range matches {
+		t.report.Matches++
+		jm := t.source.jsonMatch(m, t.columns)
+		row := m.Row
+		data := TemplateMatch{
+			Index:    t.report.Matches,
+			File:     jm.File,
+			Pattern:  jm.Pattern,
+			Function: jm.Function,
+			Start:    jm.Start,
+			End:      jm.End,
+			Code:     m.Code,
+			Text:     jm.Text,
+			Captures: jm.Captures,
+			group: func() (asq.MatchGroup, error) {
+				if !grouped {
+					groups, groupErr = asq.GroupMatches(matches[0].File, matches, t.boundary)
+					grouped = true
+				}
+				if groupErr != nil {
+					return asq.MatchGroup{}, groupErr
+				}
+				for _, g := range groups {
+					if g.StartLine <= row && row <= g.EndLine {
+						return g, nil
+					}
+				}
+				return asq.MatchGroup{}, fmt.Errorf("no group contains line %d", row)
+			},
+		}
+		if err := t.execute(t.match, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execute runs tmpl with data followed by a newline, if tmpl is set.
+func (t *templateWriter) execute(tmpl *template.Template, data any) error {
+	if tmpl == nil {
+		return nil
+	}
+	if err := tmpl.Execute(t.w, data); err != nil {
+		return err
+	}
+	_, err := io.WriteString(t.w, "\n")
+	return err
+}
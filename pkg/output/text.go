@@ -0,0 +1,237 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/StCredZero/asq/pkg/asq"
+)
+
+// ANSI escape sequences used by the text format, in ripgrep's colours.
+const (
+	colorPath    = "\x1b[35m"
+	colorLineNum = "\x1b[32m"
+	colorMatch   = "\x1b[1;31m"
+	colorCapture = "\x1b[1;33m"
+	colorReset   = "\x1b[0m"
+)
+
+// Highlight styles of a byte of source.
+const (
+	stylePlain byte = iota
+	styleMatch
+	styleCapture
+)
+
+// textWriter prints each match as an //asq_match header followed by its code.
+// With context lines it prints source lines instead, like ripgrep:
+// a heading per file, then hunks of numbered lines separated by "--", where
+// ':' follows the number of a line with a match and '-' that of a context
+// line. Matches of a file are held back until the next file starts.
+type textWriter struct {
+	w       io.Writer
+	opts    Options
+	source  source
+	pending []asq.Match
+	written bool // whether a file has been printed in source mode
+}
+
+func (t *textWriter) WriteMatch(m asq.Match) error {
+	if t.opts.Before == 0 && t.opts.After == 0 {
+		return t.writeCode(m)
+	}
+	if len(t.pending) > 0 && t.pending[0].File != m.File {
+		if err := t.flush(); err != nil {
+			return err
+		}
+	}
+	t.pending = append(t.pending, m)
+	return nil
+}
+
+func (t *textWriter) Close() error {
+	return t.flush()
+}
+
+func (t *textWriter) writeCode(m asq.Match) error {
+	col := t.source.column(m, m.StartByte, m.Col, t.opts.Columns)
+	if !t.opts.Color {
+		_, err := fmt.Fprintf(t.w, "//asq_match %s:%d:%d\n%s\n", m.File, m.Row, col, m.Code)
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("//asq_match ")
+	t.paint(&buf, colorPath, m.File)
+	buf.WriteByte(':')
+	t.paint(&buf, colorLineNum, fmt.Sprintf("%d:%d", m.Row, col))
+	buf.WriteByte('\n')
+	// The code is the whole match, so only its metavariables stand out. It
+	// holds the text of the match unless whitespace was trimmed inside it.
+	code := []byte(m.Code)
+	var styles []byte
+	if base := strings.Index(m.Code, m.Text); base >= 0 {
+		styles = make([]byte, len(code))
+		for _, c := range m.Captures {
+			if asq.IsMetavariable(c.Name) {
+				fill(styles, base+c.StartByte-m.StartByte, base+c.EndByte-m.StartByte, styleCapture)
+			}
+		}
+	}
+	t.writeLine(&buf, code, styles, 0, len(code))
+	buf.WriteByte('\n')
+	_, err := t.w.Write(buf.Bytes())
+	return err
+}
+
+// hunk is a range of 1-based line numbers printed together.
+type hunk struct {
+	first, last int
+}
+
+func (t *textWriter) flush() error {
+	if len(t.pending) == 0 {
+		return nil
+	}
+	matches := t.pending
+	t.pending = nil
+	file := matches[0].File
+
+	t.source.load(file)
+	contents := t.source.contents
+	if len(contents) == 0 {
+		// The file could not be read again; show what the matches record
+		for _, m := range matches {
+			if err := t.writeCode(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	lineStarts := []int{0}
+	for i, b := range contents {
+		if b == '\n' && i+1 < len(contents) {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	lineCount := len(lineStarts)
+
+	matchLines := make(map[int]bool)
+	hunks := make([]hunk, 0, len(matches))
+	for _, m := range matches {
+		last := m.EndRow
+		if m.EndCol == 0 && last > m.Row {
+			last-- // the match ends with a newline
+		}
+		for row := m.Row; row <= last; row++ {
+			matchLines[row] = true
+		}
+		hunks = append(hunks, hunk{max(1, m.Row-t.opts.Before), min(lineCount, last+t.opts.After)})
+	}
+	sort.Slice(hunks, func(i, j int) bool { return hunks[i].first < hunks[j].first })
+	merged := hunks[:1]
+	for _, h := range hunks[1:] {
+		cur := &merged[len(merged)-1]
+		if h.first <= cur.last+1 {
+			cur.last = max(cur.last, h.last)
+		} else {
+			merged = append(merged, h)
+		}
+	}
+
+	var styles []byte
+	if t.opts.Color {
+		styles = make([]byte, len(contents))
+		for _, m := range matches {
+			fill(styles, m.StartByte, m.EndByte, styleMatch)
+		}
+		for _, m := range matches {
+			for _, c := range m.Captures {
+				if asq.IsMetavariable(c.Name) {
+					fill(styles, c.StartByte, c.EndByte, styleCapture)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if t.written {
+		buf.WriteByte('\n')
+	}
+	t.written = true
+	t.paint(&buf, colorPath, file)
+	buf.WriteByte('\n')
+
+	width := len(strconv.Itoa(merged[len(merged)-1].last))
+	for i, h := range merged {
+		if i > 0 {
+			buf.WriteString("--\n")
+		}
+		for row := h.first; row <= h.last; row++ {
+			start := lineStarts[row-1]
+			end := len(contents)
+			if row < lineCount {
+				end = lineStarts[row] - 1
+			}
+			end = start + len(bytes.TrimRight(contents[start:end], "\r\n"))
+
+			sep := '-'
+			if matchLines[row] {
+				sep = ':'
+			}
+			t.paint(&buf, colorLineNum, fmt.Sprintf("%*d", width, row))
+			buf.WriteRune(sep)
+			t.writeLine(&buf, contents, styles, start, end)
+			buf.WriteByte('\n')
+		}
+	}
+	_, err := t.w.Write(buf.Bytes())
+	return err
+}
+
+// writeLine writes contents[start:end], highlighting it according to styles.
+func (t *textWriter) writeLine(buf *bytes.Buffer, contents, styles []byte, start, end int) {
+	if styles == nil {
+		buf.Write(contents[start:end])
+		return
+	}
+	for start < end {
+		style := styles[start]
+		next := start + 1
+		for next < end && styles[next] == style {
+			next++
+		}
+		switch style {
+		case styleMatch:
+			t.paint(buf, colorMatch, string(contents[start:next]))
+		case styleCapture:
+			t.paint(buf, colorCapture, string(contents[start:next]))
+		default:
+			buf.Write(contents[start:next])
+		}
+		start = next
+	}
+}
+
+// paint writes s, wrapped in color if colours are enabled.
+func (t *textWriter) paint(buf *bytes.Buffer, color, s string) {
+	if !t.opts.Color {
+		buf.WriteString(s)
+		return
+	}
+	buf.WriteString(color)
+	buf.WriteString(s)
+	buf.WriteString(colorReset)
+}
+
+// fill sets styles[start:end] to style, clamped to the length of styles.
+func fill(styles []byte, start, end int, style byte) {
+	for i := max(start, 0); i < min(end, len(styles)); i++ {
+		styles[i] = style
+	}
+}